@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxCaptureBodySize limits how much of each request body gets captured.
+const maxCaptureBodySize = 4 * 1024
+
+type capturedRequest struct {
+	Time          time.Time   `json:"time"`
+	Method        string      `json:"method"`
+	Host          string      `json:"host"`
+	URI           string      `json:"uri"`
+	Header        http.Header `json:"header"`
+	RemoteAddr    string      `json:"remote_addr"`
+	Body          string      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+// captureBuffer is a ring buffer which keeps the last captured requests.
+type captureBuffer struct {
+	mu       sync.Mutex
+	requests []capturedRequest
+	next     int
+	full     bool
+}
+
+func newCaptureBuffer(size int) *captureBuffer {
+	return &captureBuffer{
+		requests: make([]capturedRequest, size),
+	}
+}
+
+func (c *captureBuffer) add(req capturedRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests[c.next] = req
+	c.next = (c.next + 1) % len(c.requests)
+	if c.next == 0 {
+		c.full = true
+	}
+}
+
+// list returns the captured requests from oldest to newest.
+func (c *captureBuffer) list() []capturedRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.full {
+		return append([]capturedRequest{}, c.requests[:c.next]...)
+	}
+	return append(append([]capturedRequest{}, c.requests[c.next:]...), c.requests[:c.next]...)
+}
+
+// middleware records each request before it is passed to next.
+func (c *captureBuffer) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := capturedRequest{
+			Time:       time.Now(),
+			Method:     r.Method,
+			Host:       r.Host,
+			URI:        r.RequestURI,
+			Header:     r.Header.Clone(),
+			RemoteAddr: r.RemoteAddr,
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxCaptureBodySize+1))
+		if err != nil {
+			log.Printf("failed to capture body: %s", err)
+		}
+		if len(body) > maxCaptureBodySize {
+			req.Body = string(body[:maxCaptureBodySize])
+			req.BodyTruncated = true
+		} else {
+			req.Body = string(body)
+		}
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+		c.add(req)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *captureBuffer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(c.list())
+	if err != nil {
+		log.Println("failed to encode json:", err)
+	}
+}
+
+// withCapture records all requests in a buffer of size and serves the
+// captured requests on /captures.
+func withCapture(next http.Handler, size int) http.Handler {
+	captures := newCaptureBuffer(size)
+	recorder := captures.middleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/captures" {
+			captures.ServeHTTP(w, r)
+			return
+		}
+		recorder.ServeHTTP(w, r)
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	handler := withCapture(http.HandlerFunc(echoHandler), 2)
+
+	for _, body := range []string{"one", "two", "three"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/"+body, strings.NewReader(body)))
+		// body is still available for the handler
+		if rec.Body.String() != body {
+			t.Fatalf("got body %q, want %q", rec.Body.String(), body)
+		}
+	}
+
+	big := strings.Repeat("x", maxCaptureBodySize+1)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/big", strings.NewReader(big)))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/captures", nil))
+	captures := []capturedRequest{}
+	err := json.NewDecoder(rec.Body).Decode(&captures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captures) != 2 {
+		t.Fatalf("got %d captures, want 2", len(captures))
+	}
+	if captures[0].URI != "/three" || captures[0].Body != "three" {
+		t.Fatalf("unexpected first capture %+v", captures[0])
+	}
+	if captures[1].URI != "/big" || !captures[1].BodyTruncated || len(captures[1].Body) != maxCaptureBodySize {
+		t.Fatalf("unexpected second capture uri=%s truncated=%t len=%d", captures[1].URI, captures[1].BodyTruncated, len(captures[1].Body))
+	}
+}
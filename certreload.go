@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// certReloader serves a certificate loaded from disk which gets reloaded on
+// SIGHUP. This allows to rotate certificates without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	c.cert = &cert
+	c.mu.Unlock()
+	logCertificate(&cert)
+	return nil
+}
+
+// watchSignal reloads the certificate on every SIGHUP. If the reload fails
+// the previous certificate is kept.
+func (c *certReloader) watchSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			err := c.reload()
+			if err != nil {
+				log.Printf("failed to reload certificate: %s", err)
+				continue
+			}
+			log.Printf("reloaded certificate %s", c.certFile)
+		}
+	}()
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// sniCertificates selects a certificate by the server name (SNI) of the
+// client hello. If no certificate matches the server name or the client did
+// not send one, the first certificate is used.
+type sniCertificates []*certReloader
+
+func (s sniCertificates) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if hello.ServerName != "" {
+		for _, c := range s {
+			cert, _ := c.getCertificate(hello)
+			if cert.Leaf.VerifyHostname(hello.ServerName) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return s[0].getCertificate(hello)
+}
+
+// logCertificate logs the subject, SANs, issuer and expiry of the leaf
+// certificate of cert.
+func logCertificate(cert *tls.Certificate) {
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) == 0 {
+			return
+		}
+		var err error
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			log.Printf("failed to parse certificate: %s", err)
+			return
+		}
+	}
+	sans := append([]string{}, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	log.Printf(
+		"certificate subject=%q sans=%s issuer=%q expiry=%s",
+		leaf.Subject,
+		strings.Join(sans, ","),
+		leaf.Issuer,
+		leaf.NotAfter.Format(time.RFC3339),
+	)
+}
+
+// logFirstUse wraps getCertificate to log each certificate the first time it
+// is used. This is used for ACME where the certificates are not known at
+// startup.
+func logFirstUse(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	logged := sync.Map{}
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := getCertificate(hello)
+		if err != nil || cert == nil || len(cert.Certificate) == 0 {
+			return cert, err
+		}
+		if _, loaded := logged.LoadOrStore(string(cert.Certificate[0]), true); !loaded {
+			logCertificate(cert)
+		}
+		return cert, nil
+	}
+}
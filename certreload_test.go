@@ -0,0 +1,60 @@
+//go:build unix
+
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "first")
+
+	cfg := newDefaultTLSConfig()
+	cfg.certs = stringList{certFile}
+	cfg.keys = stringList{keyFile}
+	tlsConfig, err := cfg.getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+
+	if cn := serverCommonName(t, l.Addr().String()); cn != "first" {
+		t.Fatalf("got %s, want first", cn)
+	}
+
+	writeTestCert(t, dir, "second")
+	err = syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for serverCommonName(t, l.Addr().String()) != "second" {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
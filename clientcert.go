@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+type certificateInfo struct {
+	Subject           string    `json:"subject"`
+	Issuer            string    `json:"issuer"`
+	SerialNumber      string    `json:"serial_number"`
+	NotBefore         time.Time `json:"not_before"`
+	NotAfter          time.Time `json:"not_after"`
+	DNSNames          []string  `json:"dns_names,omitempty"`
+	EmailAddresses    []string  `json:"email_addresses,omitempty"`
+	IPAddresses       []string  `json:"ip_addresses,omitempty"`
+	URIs              []string  `json:"uris,omitempty"`
+	SHA256Fingerprint string    `json:"sha256_fingerprint"`
+}
+
+func newCertificateInfo(cert *x509.Certificate) certificateInfo {
+	fingerprint := sha256.Sum256(cert.Raw)
+	info := certificateInfo{
+		Subject:           cert.Subject.String(),
+		Issuer:            cert.Issuer.String(),
+		SerialNumber:      cert.SerialNumber.String(),
+		NotBefore:         cert.NotBefore,
+		NotAfter:          cert.NotAfter,
+		DNSNames:          cert.DNSNames,
+		EmailAddresses:    cert.EmailAddresses,
+		SHA256Fingerprint: hex.EncodeToString(fingerprint[:]),
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		info.URIs = append(info.URIs, uri.String())
+	}
+	return info
+}
+
+func newCertificateInfos(certs []*x509.Certificate) []certificateInfo {
+	infos := []certificateInfo{}
+	for _, cert := range certs {
+		infos = append(infos, newCertificateInfo(cert))
+	}
+	return infos
+}
+
+// clientCertHandler returns the client certificate chain presented by the
+// client and the chains verified by the server.
+func clientCertHandler(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		httpError(w, "no client certificate presented", http.StatusBadRequest)
+		return
+	}
+
+	resp := struct {
+		PeerCertificates []certificateInfo   `json:"peer_certificates"`
+		VerifiedChains   [][]certificateInfo `json:"verified_chains"`
+	}{
+		PeerCertificates: newCertificateInfos(r.TLS.PeerCertificates),
+		VerifiedChains:   [][]certificateInfo{},
+	}
+	for _, chain := range r.TLS.VerifiedChains {
+		resp.VerifiedChains = append(resp.VerifiedChains, newCertificateInfos(chain))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.Println("failed to encode json:", err)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientCertHandler(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(clientCertHandler))
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequestClientCert,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	// without client certificate
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	// with client certificate
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "client")
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := srv.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	client := &http.Client{Transport: transport}
+	resp, err = client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	info := struct {
+		PeerCertificates []certificateInfo `json:"peer_certificates"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.PeerCertificates) != 1 || info.PeerCertificates[0].Subject != "CN=client" {
+		t.Fatalf("unexpected peer certificates %+v", info.PeerCertificates)
+	}
+	if info.PeerCertificates[0].DNSNames[0] != "localhost" {
+		t.Fatalf("unexpected dns names %v", info.PeerCertificates[0].DNSNames)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// clientHellos keeps the TLS ClientHello of every open connection.
+//
+// The ClientHello is only available during the handshake in
+// GetConfigForClient, where we don't have access to the request. Therefore
+// the ClientHello is stored under the remote address of the connection, which
+// is unique among the open connections and available in the handler as
+// r.RemoteAddr. The entry is removed as soon as the connection is closed or
+// hijacked (see connState).
+var clientHellos = &clientHelloStore{
+	hellos: map[string]*tls.ClientHelloInfo{},
+}
+
+type clientHelloStore struct {
+	mu     sync.Mutex
+	hellos map[string]*tls.ClientHelloInfo
+}
+
+// getConfigForClient returns a GetConfigForClient function which stores the
+// ClientHello and then calls next if it is not nil.
+func (s *clientHelloStore) getConfigForClient(next func(*tls.ClientHelloInfo) (*tls.Config, error)) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		s.mu.Lock()
+		s.hellos[hello.Conn.RemoteAddr().String()] = hello
+		s.mu.Unlock()
+		if next == nil {
+			return nil, nil
+		}
+		return next(hello)
+	}
+}
+
+func (s *clientHelloStore) connState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	s.mu.Lock()
+	delete(s.hellos, c.RemoteAddr().String())
+	s.mu.Unlock()
+}
+
+func (s *clientHelloStore) get(remoteAddr string) *tls.ClientHelloInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hellos[remoteAddr]
+}
+
+type clientHelloInfo struct {
+	ServerName        string   `json:"server_name"`
+	SupportedVersions []string `json:"supported_versions"`
+	CipherSuites      []string `json:"cipher_suites"`
+	SupportedCurves   []string `json:"supported_curves"`
+	SupportedPoints   []uint8  `json:"supported_points"`
+	SignatureSchemes  []string `json:"signature_schemes"`
+	SupportedProtos   []string `json:"supported_protos"`
+}
+
+func newClientHelloInfo(hello *tls.ClientHelloInfo) clientHelloInfo {
+	info := clientHelloInfo{
+		ServerName:        hello.ServerName,
+		SupportedVersions: []string{},
+		CipherSuites:      []string{},
+		SupportedCurves:   []string{},
+		SupportedPoints:   hello.SupportedPoints,
+		SignatureSchemes:  []string{},
+		SupportedProtos:   hello.SupportedProtos,
+	}
+	for _, v := range hello.SupportedVersions {
+		info.SupportedVersions = append(info.SupportedVersions, tls.VersionName(v))
+	}
+	for _, c := range hello.CipherSuites {
+		info.CipherSuites = append(info.CipherSuites, tls.CipherSuiteName(c))
+	}
+	for _, c := range hello.SupportedCurves {
+		info.SupportedCurves = append(info.SupportedCurves, c.String())
+	}
+	for _, s := range hello.SignatureSchemes {
+		info.SignatureSchemes = append(info.SignatureSchemes, s.String())
+	}
+	return info
+}
+
+// clientHelloHandler returns the TLS ClientHello which the client sent on
+// the connection of the request.
+func clientHelloHandler(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil {
+		httpError(w, "TLS is not enabled", http.StatusBadRequest)
+		return
+	}
+	hello := clientHellos.get(r.RemoteAddr)
+	if hello == nil {
+		httpError(w, "no ClientHello recorded for this connection", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(newClientHelloInfo(hello))
+	if err != nil {
+		log.Println("failed to encode json:", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestClientHelloHandler(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(clientHelloHandler))
+	srv.TLS = &tls.Config{
+		GetConfigForClient: clientHellos.getConfigForClient(nil),
+	}
+	srv.Config.ConnState = clientHellos.connState
+	srv.StartTLS()
+	defer srv.Close()
+
+	transport := srv.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.MaxVersion = tls.VersionTLS12
+	transport.TLSClientConfig.CipherSuites = []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	}
+	transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	info := clientHelloInfo{}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, suite := range []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"} {
+		if !slices.Contains(info.CipherSuites, suite) {
+			t.Errorf("cipher suite %s missing in %v", suite, info.CipherSuites)
+		}
+	}
+	if !slices.Equal(info.SupportedProtos, []string{"http/1.1"}) {
+		t.Errorf("got protos %v, want [http/1.1]", info.SupportedProtos)
+	}
+
+	// without TLS
+	rec := httptest.NewRecorder()
+	clientHelloHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
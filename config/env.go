@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expandEnv replaces ${VAR} in value with the environment variable VAR. If VAR
+// is not set an error is returned. With ${VAR:-default} the default is used if
+// VAR is not set or empty. $${ results in a literal ${.
+func expandEnv(value string) (string, error) {
+	result := strings.Builder{}
+	for {
+		start := strings.IndexByte(value, '$')
+		if start == -1 {
+			result.WriteString(value)
+			return result.String(), nil
+		}
+		result.WriteString(value[:start])
+		value = value[start:]
+		if strings.HasPrefix(value, "$${") {
+			result.WriteString("${")
+			value = value[3:]
+			continue
+		}
+		if !strings.HasPrefix(value, "${") {
+			result.WriteByte('$')
+			value = value[1:]
+			continue
+		}
+		end := strings.IndexByte(value, '}')
+		if end == -1 {
+			return "", fmt.Errorf("unterminated variable '%s'", value)
+		}
+
+		expr := value[2:end]
+		name, def, hasDefault := strings.Cut(expr, ":-")
+		if name == "" {
+			return "", fmt.Errorf("missing variable name in '${%s}'", expr)
+		}
+		envValue, ok := os.LookupEnv(name)
+		switch {
+		case hasDefault && envValue == "":
+			result.WriteString(def)
+		case !ok:
+			return "", fmt.Errorf("environment variable '%s' is not set", name)
+		default:
+			result.WriteString(envValue)
+		}
+		value = value[end+1:]
+	}
+}
+
+// expandSettingsEnv applies expandEnv to all setting values of mappings.
+func expandSettingsEnv(mappings map[string][]HandlerConfig) error {
+	for path, chain := range mappings {
+		for _, cfg := range chain {
+			for key, values := range cfg.Settings {
+				for i, value := range values {
+					value, err := expandEnv(value)
+					if err != nil {
+						return fmt.Errorf("invalid value of '%s' of %s in path '%s': %w", key, cfg.Name, path, err)
+					}
+					values[i] = value
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatDSL  = "dsl"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// ParseFormat parses the input according to format. The structured formats
+// (JSON and YAML) map each path to a list of handler configs, e.g.:
+//
+//	{"/": [{"name": "log"}, {"name": "static", "settings": {"body": "foo"}}]}
+func ParseFormat(format string, input []byte) (map[string][]HandlerConfig, error) {
+	switch format {
+	case "", FormatDSL:
+		return Parse(input)
+	case FormatJSON:
+		return ParseJSON(input)
+	case FormatYAML:
+		return ParseYAML(input)
+	default:
+		return nil, fmt.Errorf("unknown config format '%s'", format)
+	}
+}
+
+func ParseJSON(input []byte) (map[string][]HandlerConfig, error) {
+	err := checkInputSize(input)
+	if err != nil {
+		return nil, err
+	}
+	mappings := map[string][]HandlerConfig{}
+	err = json.Unmarshal(input, &mappings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse json config: %w", err)
+	}
+	err = validate(mappings)
+	if err != nil {
+		return nil, err
+	}
+	return mappings, expandSettingsEnv(mappings)
+}
+
+func ParseYAML(input []byte) (map[string][]HandlerConfig, error) {
+	err := checkInputSize(input)
+	if err != nil {
+		return nil, err
+	}
+	mappings := map[string][]HandlerConfig{}
+	err = yaml.Unmarshal(input, &mappings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse yaml config: %w", err)
+	}
+	err = validate(mappings)
+	if err != nil {
+		return nil, err
+	}
+	return mappings, expandSettingsEnv(mappings)
+}
+
+func validate(mappings map[string][]HandlerConfig) error {
+	for path, chain := range mappings {
+		// path with optional host prefix (e.g. example.com/foo)
+		if path != GlobalKey && !strings.Contains(path, "/") {
+			return fmt.Errorf("invalid path '%s': must contain '/'", path)
+		}
+		for _, cfg := range chain {
+			if cfg.Name == "" {
+				return fmt.Errorf("missing name in chain of path '%s'", path)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFormatEquivalence(t *testing.T) {
+	for i, test := range []struct {
+		dsl  string
+		json string
+		yaml string
+	}{
+		{
+			dsl:  "static",
+			json: `{"/": [{"name": "static"}]}`,
+			yaml: "/:\n  - name: static\n",
+		},
+		{
+			dsl:  `/api: log static{body: "foo bar", code: 404} /: info`,
+			json: `{"/api": [{"name": "log"}, {"name": "static", "settings": {"body": "foo bar", "code": "404"}}], "/": [{"name": "info"}]}`,
+			yaml: "/api:\n  - name: log\n  - name: static\n    settings:\n      body: foo bar\n      code: \"404\"\n/:\n  - name: info\n",
+		},
+		{
+			dsl:  "header{X-Test: a, X-Test: b} static",
+			json: `{"/": [{"name": "header", "settings": {"X-Test": ["a", "b"]}}, {"name": "static"}]}`,
+			yaml: "/:\n  - name: header\n    settings:\n      X-Test: [a, b]\n  - name: static\n",
+		},
+	} {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			expected, err := ParseFormat(FormatDSL, []byte(test.dsl))
+			if err != nil {
+				t.Fatalf("failed to parse '%s'. %s", test.dsl, err)
+			}
+
+			for format, input := range map[string]string{
+				FormatJSON: test.json,
+				FormatYAML: test.yaml,
+			} {
+				got, err := ParseFormat(format, []byte(input))
+				if err != nil {
+					t.Fatalf("failed to parse %s '%s'. %s", format, input, err)
+				}
+				if !reflect.DeepEqual(got, expected) {
+					t.Fatalf("%s not equivalent to dsl. got: %#v, want: %#v", format, got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatTooLarge(t *testing.T) {
+	input := []byte(strings.Repeat(" ", MaxInputSize+1))
+	for _, format := range []string{FormatDSL, FormatJSON, FormatYAML} {
+		_, err := ParseFormat(format, input)
+		if err == nil || !strings.Contains(err.Error(), "config too large") {
+			t.Fatalf("%s: got error %v, want config too large", format, err)
+		}
+	}
+}
+
+func TestFormatInvalid(t *testing.T) {
+	for _, input := range []string{
+		`{"api": [{"name": "static"}]}`,
+		`{"/": [{"settings": {"body": "foo"}}]}`,
+	} {
+		_, err := ParseFormat(FormatJSON, []byte(input))
+		if err == nil {
+			t.Fatalf("expected error for '%s'", input)
+		}
+	}
+}
+
+func TestFormatEnv(t *testing.T) {
+	t.Setenv("HTTP_SERVER_TEST_BODY", "foo")
+	for format, input := range map[string]string{
+		FormatJSON: `{"/": [{"name": "static", "settings": {"body": "${HTTP_SERVER_TEST_BODY} $${HTTP_SERVER_TEST_BODY}", "code": "${HTTP_SERVER_TEST_MISSING:-404}"}}]}`,
+		FormatYAML: "/:\n  - name: static\n    settings:\n      body: ${HTTP_SERVER_TEST_BODY} $${HTTP_SERVER_TEST_BODY}\n      code: ${HTTP_SERVER_TEST_MISSING:-404}\n",
+	} {
+		got, err := ParseFormat(format, []byte(input))
+		if err != nil {
+			t.Fatalf("failed to parse %s '%s'. %s", format, input, err)
+		}
+		expected := map[string][]HandlerConfig{
+			"/": {{Name: "static", Settings: Settings{"body": {"foo ${HTTP_SERVER_TEST_BODY}"}, "code": {"404"}}}},
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("%s: got: %#v, want: %#v", format, got, expected)
+		}
+	}
+
+	for format, input := range map[string]string{
+		FormatJSON: `{"/": [{"name": "static", "settings": {"body": "${HTTP_SERVER_TEST_MISSING}"}}]}`,
+		FormatYAML: "/:\n  - name: static\n    settings:\n      body: ${HTTP_SERVER_TEST_MISSING}\n",
+	} {
+		_, err := ParseFormat(format, []byte(input))
+		if err == nil || !strings.Contains(err.Error(), "environment variable 'HTTP_SERVER_TEST_MISSING' is not set") {
+			t.Fatalf("%s: got error %v", format, err)
+		}
+	}
+}
@@ -1,27 +1,61 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 	"unicode"
 )
 
 func ParseArgs(args []string) (map[string][]HandlerConfig, error) {
+	return ParseArgsFormat(FormatDSL, args)
+}
+
+func ParseArgsFormat(format string, args []string) (map[string][]HandlerConfig, error) {
 	config := []byte(strings.Join(args, " "))
-	return Parse(config)
+	return ParseFormat(format, config)
+}
+
+var (
+	// MaxInputSize is the maximum size of a configuration in bytes.
+	MaxInputSize = 1 << 20 // 1MB
+	// MaxTokenLength is the maximum length of a single word or string in
+	// the configuration.
+	MaxTokenLength = 64 << 10 // 64KB
+)
+
+// checkInputSize returns an error if input exceeds MaxInputSize.
+func checkInputSize(input []byte) error {
+	if len(input) > MaxInputSize {
+		return fmt.Errorf("config too large: %d bytes exceeds the maximum of %d bytes", len(input), MaxInputSize)
+	}
+	return nil
 }
 
 func Parse(input []byte) (map[string][]HandlerConfig, error) {
+	err := checkInputSize(input)
+	if err != nil {
+		return nil, err
+	}
 	p := &parser{
-		input: input,
-		pos:   0,
+		input:          input,
+		pos:            0,
+		maxTokenLength: MaxTokenLength,
 	}
 	return p.parse()
 }
 
 type parser struct {
-	input []byte
-	pos   int
+	input          []byte
+	pos            int
+	maxTokenLength int
+}
+
+func (p *parser) checkTokenLength(start int) error {
+	if p.maxTokenLength > 0 && p.pos-start > p.maxTokenLength {
+		return fmt.Errorf("token starting at offset %d exceeds the maximum length of %d", start+1, p.maxTokenLength)
+	}
+	return nil
 }
 
 func (p *parser) peek() (byte, bool) {
@@ -61,18 +95,21 @@ func (p *parser) skipSpace() {
 	}
 }
 
+// readQuotedString reads a string enclosed in double quotes. Within the string
+// the escape sequences \", \\, \n and \t are decoded.
 func (p *parser) readQuotedString() (string, error) {
 	err := p.consume('"')
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
 	start := p.pos
+	value := strings.Builder{}
 
 	for {
 		c, ok := p.peek()
 		if !ok {
-			return "", fmt.Errorf("unexpected EOF. end of string not found")
+			return "", fmt.Errorf("unexpected EOF. unterminated string starting at offset %d", start)
 		}
 
 		if c == '"' {
@@ -80,8 +117,32 @@ func (p *parser) readQuotedString() (string, error) {
 			break
 		}
 		p.next()
+		if err := p.checkTokenLength(start); err != nil {
+			return "", err
+		}
+
+		if c != '\\' {
+			value.WriteByte(c)
+			continue
+		}
+
+		c, ok = p.peek()
+		if !ok {
+			return "", fmt.Errorf("unexpected EOF after '\\' in string starting at offset %d", start)
+		}
+		switch c {
+		case '"', '\\':
+			value.WriteByte(c)
+		case 'n':
+			value.WriteByte('\n')
+		case 't':
+			value.WriteByte('\t')
+		default:
+			return "", fmt.Errorf("invalid escape sequence '\\%c' at offset %d", c, p.pos)
+		}
+		p.next()
 	}
-	return string(p.input[start : p.pos-1]), nil
+	return value.String(), nil
 }
 
 func (p *parser) readNakedString() (string, error) {
@@ -98,7 +159,24 @@ func (p *parser) readNakedString() (string, error) {
 			break
 		}
 		p.next()
-
+		// environment variables (e.g. ${VAR:-default}) may contain
+		// characters which otherwise end the word
+		if c == '$' {
+			if next, _ := p.peek(); next == '{' {
+				end := bytes.IndexByte(p.input[p.pos:], '}')
+				if end == -1 {
+					return "", fmt.Errorf("unterminated variable starting at offset %d", p.pos)
+				}
+				p.pos += end + 1
+			}
+		}
+		if err := p.checkTokenLength(start); err != nil {
+			return "", err
+		}
+	}
+	if p.pos == start {
+		c, _ := p.peek()
+		return "", fmt.Errorf("unexpected '%c' at offset %d expected word", c, p.pos+1)
 	}
 	return string(p.input[start:p.pos]), nil
 }
@@ -116,39 +194,54 @@ func (p *parser) readWord() (string, error) {
 	return v, err
 }
 
-func (p *parser) parseSettings() (map[string]string, error) {
-	result := map[string]string{}
+func (p *parser) parseSettings() (Settings, error) {
+	result := Settings{}
 
+	start := p.pos
 	err := p.consume('{')
 	if err != nil {
 		return nil, err
 	}
 
+	// unterminated returns a descriptive error if we reached EOF within the
+	// settings block and err otherwise
+	unterminated := func(err error) error {
+		if _, ok := p.peek(); !ok {
+			return fmt.Errorf("unterminated settings block starting at offset %d: missing '}'", start+1)
+		}
+		return err
+	}
+
 	p.skipSpace()
 
 	for {
 		key, err := p.readWord()
 		if err != nil {
-			return nil, fmt.Errorf("failed to read key: %w", err)
+			return nil, unterminated(fmt.Errorf("failed to read key: %w", err))
 		}
 
 		err = p.consume(':')
 		if err != nil {
-			return nil, err
+			return nil, unterminated(err)
 		}
 
 		p.skipSpace()
+		valueStart := p.pos
 		value, err := p.readWord()
 		if err != nil {
-			return nil, fmt.Errorf("failed to read value: %w", err)
+			return nil, unterminated(fmt.Errorf("failed to read value: %w", err))
+		}
+		value, err = expandEnv(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of '%s' at offset %d: %w", key, valueStart+1, err)
 		}
-		result[key] = value
+		result.Add(key, value)
 
 		p.skipSpace()
 
 		c, ok := p.peek()
 		if !ok {
-			return nil, fmt.Errorf("expected ',' or '}' at offset %d, got '%c'", p.pos+1, c)
+			return nil, unterminated(nil)
 		}
 		if c == '}' {
 			p.next()
@@ -164,14 +257,33 @@ func (p *parser) parseSettings() (map[string]string, error) {
 	return result, nil
 }
 
+// GlobalKey is the key of the middlewares which are applied to every route.
+const GlobalKey = "@global"
+
 type HandlerConfig struct {
-	Name     string
-	Settings map[string]string
+	Name     string   `json:"name" yaml:"name"`
+	Settings Settings `json:"settings,omitempty" yaml:"settings,omitempty"`
+}
+
+// isMethod reports whether word is an HTTP method. Methods are distinguished
+// from handler names by being upper case.
+func isMethod(word string) bool {
+	if word == "" {
+		return false
+	}
+	for _, c := range word {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
 }
 
 func (p *parser) parse() (map[string][]HandlerConfig, error) {
 	mappings := map[string][]HandlerConfig{}
-	currentPath := "/"
+	currentHost := ""
+	currentMethod := ""
+	currentPaths := []string{"/"}
 	for {
 
 		p.skipSpace()
@@ -185,9 +297,64 @@ func (p *parser) parse() (map[string][]HandlerConfig, error) {
 			return nil, err
 		}
 
-		// path
+		// middlewares which are applied to all routes
+		if word == GlobalKey {
+			currentHost = ""
+			currentMethod = ""
+			currentPaths = []string{GlobalKey}
+			continue
+		}
+
+		// virtual host. the host is used as prefix for the paths which
+		// follow (e.g. example.com/foo)
+		if strings.HasPrefix(word, "@") {
+			currentHost = word[1:]
+			if currentHost == "" {
+				return nil, fmt.Errorf("missing host after '@' at %d", p.pos)
+			}
+			currentMethod = ""
+			currentPaths = []string{"/"}
+			continue
+		}
+
+		// method followed by a path (e.g. POST /api: echo). the method is
+		// used as prefix of the pattern (e.g. POST example.com/api)
+		method := ""
+		if isMethod(word) {
+			method = word
+			p.skipSpace()
+			word, err = p.readWord()
+			if err != nil {
+				return nil, fmt.Errorf("missing path after method '%s': %w", method, err)
+			}
+			if !strings.HasPrefix(word, "/") {
+				return nil, fmt.Errorf("invalid path '%s' at %d: path has to start with '/'", word, p.pos)
+			}
+		}
+
+		// path or comma separated list of paths which share the same
+		// chain (e.g. /a, /b: static)
 		if strings.HasPrefix(word, "/") {
-			currentPath = word
+			currentMethod = ""
+			if method != "" {
+				currentMethod = method + " "
+			}
+			currentPaths = []string{word}
+			for {
+				if c, _ := p.peek(); c != ',' {
+					break
+				}
+				p.next()
+				p.skipSpace()
+				word, err = p.readWord()
+				if err != nil {
+					return nil, err
+				}
+				if !strings.HasPrefix(word, "/") {
+					return nil, fmt.Errorf("invalid path '%s' at %d: path has to start with '/'", word, p.pos)
+				}
+				currentPaths = append(currentPaths, word)
+			}
 			err := p.consume(':')
 			if err != nil {
 				return nil, fmt.Errorf("missing ':' after path '%s' at %d", word, p.pos+1)
@@ -209,7 +376,10 @@ func (p *parser) parse() (map[string][]HandlerConfig, error) {
 			config.Settings = settings
 		}
 
-		mappings[currentPath] = append(mappings[currentPath], config)
+		for _, path := range currentPaths {
+			pattern := currentMethod + currentHost + path
+			mappings[pattern] = append(mappings[pattern], config)
+		}
 		if !ok {
 			break
 		}
@@ -3,37 +3,59 @@ package config
 import (
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 )
 
 func TestSettings(t *testing.T) {
 	for i, test := range []struct {
 		input    string
-		expected map[string]string
+		expected Settings
 	}{
 		{
 			input: "{ foo: bar }",
-			expected: map[string]string{
-				"foo": "bar",
+			expected: Settings{
+				"foo": {"bar"},
 			},
 		},
 		{
 			input: `{foo: "bar bla" }`,
-			expected: map[string]string{
-				"foo": "bar bla",
+			expected: Settings{
+				"foo": {"bar bla"},
 			},
 		},
 		{
 			input: "{foo: bar, bla: baz}",
-			expected: map[string]string{
-				"foo": "bar",
-				"bla": "baz",
+			expected: Settings{
+				"foo": {"bar"},
+				"bla": {"baz"},
 			},
 		},
 		{
 			input: `{body: "foo bar bla"}`,
-			expected: map[string]string{
-				"body": "foo bar bla",
+			expected: Settings{
+				"body": {"foo bar bla"},
+			},
+		},
+		{
+			input: "{Set-Cookie: a=1, Set-Cookie: b=2}",
+			expected: Settings{
+				"Set-Cookie": {"a=1", "b=2"},
+			},
+		},
+		{
+			input: `{body: "{\"foo\": \"bar\"}"}`,
+			expected: Settings{
+				"body": {`{"foo": "bar"}`},
+			},
+		},
+		{
+			input: `{a: "back\\slash", b: "new\nline", c: "t\tab", d: "\"quoted\""}`,
+			expected: Settings{
+				"a": {`back\slash`},
+				"b": {"new\nline"},
+				"c": {"t\tab"},
+				"d": {`"quoted"`},
 			},
 		},
 	} {
@@ -51,6 +73,73 @@ func TestSettings(t *testing.T) {
 	}
 }
 
+func TestSettingsEnv(t *testing.T) {
+	t.Setenv("HTTP_SERVER_TEST_PASSWORD", "secret")
+	t.Setenv("HTTP_SERVER_TEST_EMPTY", "")
+	for _, test := range []struct {
+		input    string
+		expected Settings
+		err      string
+	}{
+		{
+			input:    "{password: ${HTTP_SERVER_TEST_PASSWORD}}",
+			expected: Settings{"password": {"secret"}},
+		},
+		{
+			input:    `{password: "user:${HTTP_SERVER_TEST_PASSWORD}!"}`,
+			expected: Settings{"password": {"user:secret!"}},
+		},
+		{
+			input:    "{password: ${HTTP_SERVER_TEST_MISSING:-default}, user: admin}",
+			expected: Settings{"password": {"default"}, "user": {"admin"}},
+		},
+		{
+			input:    "{password: ${HTTP_SERVER_TEST_EMPTY:-default}}",
+			expected: Settings{"password": {"default"}},
+		},
+		{
+			input:    "{password: ${HTTP_SERVER_TEST_MISSING:-}}",
+			expected: Settings{"password": {""}},
+		},
+		{
+			input:    "{password: ${HTTP_SERVER_TEST_EMPTY}}",
+			expected: Settings{"password": {""}},
+		},
+		{
+			input:    `{password: "$${HTTP_SERVER_TEST_PASSWORD} $5 $"}`,
+			expected: Settings{"password": {"${HTTP_SERVER_TEST_PASSWORD} $5 $"}},
+		},
+		{
+			input: "{password: ${HTTP_SERVER_TEST_MISSING}}",
+			err:   "invalid value of 'password' at offset 12: environment variable 'HTTP_SERVER_TEST_MISSING' is not set",
+		},
+		{
+			input: `{password: "${HTTP_SERVER_TEST_PASSWORD"}`,
+			err:   "unterminated variable",
+		},
+		{
+			input: "{password: ${HTTP_SERVER_TEST_PASSWORD",
+			err:   "unterminated variable",
+		},
+	} {
+		p := &parser{input: []byte(test.input)}
+		got, err := p.parseSettings()
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, want %q", test.input, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: got %#v, want %#v", test.input, got, test.expected)
+		}
+	}
+}
+
 func TestConfig(t *testing.T) {
 	for i, test := range []struct {
 		input    string
@@ -73,8 +162,8 @@ func TestConfig(t *testing.T) {
 				"/": {
 					{
 						Name: "static",
-						Settings: map[string]string{
-							"body": "foo bar bla",
+						Settings: Settings{
+							"body": {"foo bar bla"},
 						},
 					},
 				},
@@ -91,6 +180,81 @@ func TestConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			input: "info @api.example.com /users: static @www.example.com echo",
+			expected: map[string][]HandlerConfig{
+				"/": {
+					{
+						Name: "info",
+					},
+				},
+				"api.example.com/users": {
+					{
+						Name: "static",
+					},
+				},
+				"www.example.com/": {
+					{
+						Name: "echo",
+					},
+				},
+			},
+		},
+		{
+			input: "@global log /foo: static",
+			expected: map[string][]HandlerConfig{
+				"@global": {
+					{
+						Name: "log",
+					},
+				},
+				"/foo": {
+					{
+						Name: "static",
+					},
+				},
+			},
+		},
+		{
+			input: "/a, /b,/c: log static{body: shared} /d: echo",
+			expected: map[string][]HandlerConfig{
+				"/a": {
+					{Name: "log"},
+					{Name: "static", Settings: Settings{"body": {"shared"}}},
+				},
+				"/b": {
+					{Name: "log"},
+					{Name: "static", Settings: Settings{"body": {"shared"}}},
+				},
+				"/c": {
+					{Name: "log"},
+					{Name: "static", Settings: Settings{"body": {"shared"}}},
+				},
+				"/d": {
+					{Name: "echo"},
+				},
+			},
+		},
+		{
+			input: "GET /api:static POST /api, /other: echo /: info @example.com DELETE /api: static",
+			expected: map[string][]HandlerConfig{
+				"GET /api": {
+					{Name: "static"},
+				},
+				"POST /api": {
+					{Name: "echo"},
+				},
+				"POST /other": {
+					{Name: "echo"},
+				},
+				"/": {
+					{Name: "info"},
+				},
+				"DELETE example.com/api": {
+					{Name: "static"},
+				},
+			},
+		},
 	} {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
 			got, err := Parse([]byte(test.input))
@@ -104,3 +268,64 @@ func TestConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		err   string
+	}{
+		{
+			input: "static{body: foo",
+			err:   "unterminated settings block starting at offset 7",
+		},
+		{
+			input: "static{body: ",
+			err:   "unterminated settings block starting at offset 7",
+		},
+		{
+			input: `static{body: "foo}`,
+			err:   "unterminated settings block starting at offset 7",
+		},
+		{
+			input: "static}",
+			err:   "unexpected '}' at offset 7",
+		},
+		{
+			input: "static{body: " + strings.Repeat("a", MaxTokenLength+1) + "}",
+			err:   "token starting at offset 14 exceeds the maximum length",
+		},
+		{
+			input: `static{body: "\x"}`,
+			err:   "invalid escape sequence '\\x' at offset 15",
+		},
+		{
+			input: `"foo\`,
+			err:   "unexpected EOF after '\\' in string starting at offset 1",
+		},
+		{
+			input: "/a, b: static",
+			err:   "invalid path 'b'",
+		},
+		{
+			input: "GET static",
+			err:   "invalid path 'static'",
+		},
+		{
+			input: "GET",
+			err:   "missing path after method 'GET'",
+		},
+		{
+			input: strings.Repeat(" ", MaxInputSize+1),
+			err:   "config too large",
+		},
+	} {
+		_, err := Parse([]byte(test.input))
+		if err == nil {
+			t.Errorf("expected error for '%.30s'", test.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("got error '%s', want '%s'", err, test.err)
+		}
+	}
+}
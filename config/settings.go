@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Settings holds the settings of a handler or middleware. A key can be
+// specified multiple times, e.g. header{Set-Cookie: a, Set-Cookie: b}.
+type Settings map[string][]string
+
+// Lookup returns the last value of key and whether the key is set.
+func (s Settings) Lookup(key string) (string, bool) {
+	values := s[key]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// Get returns the last value of key or an empty string if the key is not
+// set.
+func (s Settings) Get(key string) string {
+	value, _ := s.Lookup(key)
+	return value
+}
+
+// List returns all values of key split by comma. Empty elements are
+// omitted. This allows to specify lists as repeated keys or as a quoted comma
+// separated value (e.g. methods: "GET,POST").
+func (s Settings) List(key string) []string {
+	list := []string{}
+	for _, value := range s[key] {
+		for _, element := range strings.Split(value, ",") {
+			element = strings.TrimSpace(element)
+			if element == "" {
+				continue
+			}
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+// Int returns the value of key as int or def if the key is not set.
+func (s Settings) Int(key string, def int) (int, error) {
+	value, ok := s.Lookup(key)
+	if !ok {
+		return def, nil
+	}
+	num, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for '%s': '%s' is not a number", key, value)
+	}
+	return num, nil
+}
+
+// Duration returns the value of key as time.Duration or def if the key is not
+// set.
+func (s Settings) Duration(key string, def time.Duration) (time.Duration, error) {
+	value, ok := s.Lookup(key)
+	if !ok {
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for '%s': %w", key, err)
+	}
+	return d, nil
+}
+
+// Bool returns the value of key as bool or def if the key is not set.
+func (s Settings) Bool(key string, def bool) (bool, error) {
+	value, ok := s.Lookup(key)
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for '%s': '%s' is not a boolean", key, value)
+	}
+	return b, nil
+}
+
+// Add appends value to the values of key.
+func (s Settings) Add(key, value string) {
+	s[key] = append(s[key], value)
+}
+
+// UnmarshalJSON accepts a string or a list of strings per key.
+func (s *Settings) UnmarshalJSON(data []byte) error {
+	raw := map[string]json.RawMessage{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	settings := Settings{}
+	for key, rawValue := range raw {
+		var value string
+		if err := json.Unmarshal(rawValue, &value); err == nil {
+			settings.Add(key, value)
+			continue
+		}
+		var values []string
+		if err := json.Unmarshal(rawValue, &values); err != nil {
+			return fmt.Errorf("invalid value for setting '%s': expected string or list of strings", key)
+		}
+		settings[key] = append(settings[key], values...)
+	}
+	*s = settings
+	return nil
+}
+
+// UnmarshalYAML accepts a string or a list of strings per key.
+func (s *Settings) UnmarshalYAML(node *yaml.Node) error {
+	raw := map[string]yaml.Node{}
+	err := node.Decode(&raw)
+	if err != nil {
+		return err
+	}
+	settings := Settings{}
+	for key, rawValue := range raw {
+		if rawValue.Kind == yaml.SequenceNode {
+			var values []string
+			if err := rawValue.Decode(&values); err != nil {
+				return fmt.Errorf("invalid value for setting '%s': %w", key, err)
+			}
+			settings[key] = append(settings[key], values...)
+			continue
+		}
+		var value string
+		if err := rawValue.Decode(&value); err != nil {
+			return fmt.Errorf("invalid value for setting '%s': %w", key, err)
+		}
+		settings.Add(key, value)
+	}
+	*s = settings
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// connTracker keeps track of the state transitions of each connection to log
+// why and after which time a connection has been closed.
+type connTracker struct {
+	idleTimeout time.Duration
+
+	mu    sync.Mutex
+	conns map[net.Conn]*connInfo
+}
+
+type connInfo struct {
+	created  time.Time
+	state    http.ConnState
+	since    time.Time
+	requests int
+}
+
+func newConnTracker(idleTimeout time.Duration) *connTracker {
+	return &connTracker{
+		idleTimeout: idleTimeout,
+		conns:       map[net.Conn]*connInfo{},
+	}
+}
+
+func (t *connTracker) connState(c net.Conn, state http.ConnState) {
+	now := time.Now()
+
+	t.mu.Lock()
+	info, ok := t.conns[c]
+	if !ok {
+		info = &connInfo{
+			created: now,
+			state:   http.StateNew,
+			since:   now,
+		}
+		t.conns[c] = info
+	}
+	prevState := info.state
+	prevSince := info.since
+	info.state = state
+	info.since = now
+	if state == http.StateActive {
+		info.requests++
+	}
+	if state == http.StateClosed || state == http.StateHijacked {
+		delete(t.conns, c)
+	}
+	t.mu.Unlock()
+
+	switch state {
+	case http.StateNew:
+		log.Printf("new src=%s", c.RemoteAddr())
+	case http.StateIdle, http.StateActive:
+		return
+	default:
+		log.Printf(
+			"%s src=%s reason=%q age=%s requests=%d",
+			state,
+			c.RemoteAddr(),
+			t.reason(state, prevState, now.Sub(prevSince)),
+			now.Sub(info.created),
+			info.requests,
+		)
+	}
+}
+
+func (t *connTracker) reason(state, prevState http.ConnState, inPrevState time.Duration) string {
+	if state == http.StateHijacked {
+		return "hijacked"
+	}
+	switch prevState {
+	case http.StateNew:
+		return "closed before first request (error or timeout)"
+	case http.StateActive:
+		return "closed after request"
+	case http.StateIdle:
+		if t.idleTimeout > 0 && inPrevState >= t.idleTimeout {
+			return "idle timeout after " + inPrevState.Round(time.Millisecond).String()
+		}
+		return "closed while idle after " + inPrevState.Round(time.Millisecond).String()
+	}
+	return "unknown"
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnTrackerReason(t *testing.T) {
+	tracker := newConnTracker(time.Second)
+	for _, test := range []struct {
+		state     http.ConnState
+		prevState http.ConnState
+		duration  time.Duration
+		expected  string
+	}{
+		{http.StateClosed, http.StateIdle, 2 * time.Second, "idle timeout"},
+		{http.StateClosed, http.StateIdle, time.Millisecond, "closed while idle"},
+		{http.StateClosed, http.StateActive, time.Millisecond, "closed after request"},
+		{http.StateClosed, http.StateNew, time.Millisecond, "closed before first request"},
+		{http.StateHijacked, http.StateActive, time.Millisecond, "hijacked"},
+	} {
+		got := tracker.reason(test.state, test.prevState, test.duration)
+		if !strings.HasPrefix(got, test.expected) {
+			t.Errorf("%s -> %s: got %q, want prefix %q", test.prevState, test.state, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// errorFormat is the format of the error responses of the built-in handlers
+// and middlewares (text or json).
+var errorFormat = errorFormatText
+
+func setErrorFormat(format string) error {
+	if format != errorFormatText && format != errorFormatJSON {
+		return fmt.Errorf("invalid error format '%s'", format)
+	}
+	errorFormat = format
+	return nil
+}
+
+// httpError replies to the request with the error message and code like
+// http.Error. With the error format json the response has the form
+// {"error": "...", "status": 400}.
+func httpError(w http.ResponseWriter, msg string, code int) {
+	if errorFormat != errorFormatJSON {
+		http.Error(w, msg, code)
+		return
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error  string `json:"error"`
+		Status int    `json:"status"`
+	}{
+		Error:  msg,
+		Status: code,
+	})
+}
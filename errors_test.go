@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorFormatJSON(t *testing.T) {
+	err := setErrorFormat(errorFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setErrorFormat(errorFormatText)
+
+	rec := httptest.NewRecorder()
+	dataHandler(rec, httptest.NewRequest(http.MethodGet, "/?size=abc", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("got content type %q, want application/json", ct)
+	}
+	resp := struct {
+		Error  string `json:"error"`
+		Status int    `json:"status"`
+	}{}
+	err = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != http.StatusBadRequest || !strings.HasPrefix(resp.Error, "invalid size: ") {
+		t.Fatalf("unexpected error response %+v", resp)
+	}
+
+	err = setErrorFormat("xml")
+	if err == nil {
+		t.Fatal("expected error for invalid format")
+	}
+}
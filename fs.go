@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+
+	"github.com/dvob/http-server/config"
+)
+
+// newFSHandler serves a single file (setting file) or a directory tree
+// (setting dir). In directory mode the following settings are available:
+//   - strip: prefix which is removed from the request path
+//   - browse: enables directory listings (default true)
+//   - fallback: file which is served for missing files (see newDirHandler)
+func newFSHandler(config config.Settings) (http.Handler, error) {
+	file, hasFile := config.Lookup("file")
+	dir, hasDir := config.Lookup("dir")
+	if hasFile && hasDir {
+		return nil, fmt.Errorf("'file' and 'dir' can not be used together")
+	}
+	if hasDir {
+		browse, err := config.Bool("browse", true)
+		if err != nil {
+			return nil, err
+		}
+		handler := newDirHandler(dir, config.Get("fallback"), browse)
+		if strip, ok := config.Lookup("strip"); ok {
+			handler = http.StripPrefix(strip, handler)
+		}
+		return withContentHeaders(config, handler), nil
+	}
+	if !hasFile {
+		return nil, fmt.Errorf("missing configuration 'file' or 'dir'")
+	}
+	return withContentHeaders(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, file)
+	})), nil
+}
+
+// newDirHandler serves the files in dir. If fallback is set, requests for
+// files which do not exist are answered with the fallback file (e.g.
+// index.html of a single-page app). Paths with a file extension (e.g.
+// /app.js) are considered assets and still return 404 if they are missing.
+// If browse is false directories without index.html return 404 instead of a
+// listing.
+func newDirHandler(dir, fallback string, browse bool) http.Handler {
+	var root http.FileSystem = http.Dir(dir)
+	if !browse {
+		root = noListingFS{root}
+	}
+	fileServer := http.FileServer(root)
+	if fallback == "" {
+		return fileServer
+	}
+	fallbackFile := filepath.Join(dir, filepath.FromSlash(fallback))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		f, err := root.Open(name)
+		if err == nil {
+			f.Close()
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		if !errors.Is(err, fs.ErrNotExist) || path.Ext(name) != "" {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		http.ServeFile(w, r, fallbackFile)
+	})
+}
+
+// noListingFS hides directories without an index.html so that http.FileServer
+// does not list their content.
+type noListingFS struct {
+	http.FileSystem
+}
+
+func (n noListingFS) Open(name string) (http.File, error) {
+	f, err := n.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !info.IsDir() {
+		return f, nil
+	}
+	index, err := n.FileSystem.Open(path.Join(name, "index.html"))
+	if err != nil {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	index.Close()
+	return f, nil
+}
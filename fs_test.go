@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dvob/http-server/config"
+)
+
+func TestFSFallback(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"index.html": "index",
+		"app.js":     "app",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler, err := newFSHandler(config.Settings{"dir": {dir}, "fallback": {"index.html"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/app.js", http.StatusOK, "app"},
+		{"/some/spa/route", http.StatusOK, "index"},
+		{"/missing.js", http.StatusNotFound, ""},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.code {
+			t.Errorf("%s: got %d, want %d", test.path, rec.Code, test.code)
+		}
+		if test.code == http.StatusOK && rec.Body.String() != test.body {
+			t.Errorf("%s: got %q, want %q", test.path, rec.Body.String(), test.body)
+		}
+	}
+}
+
+func TestFSDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte("file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		settings config.Settings
+		path     string
+		code     int
+		body     string
+	}{
+		{"file", config.Settings{"dir": {dir}}, "/sub/file.txt", http.StatusOK, "file"},
+		{"listing", config.Settings{"dir": {dir}}, "/sub/", http.StatusOK, ""},
+		{"no listing", config.Settings{"dir": {dir}, "browse": {"false"}}, "/sub/", http.StatusNotFound, ""},
+		{"no listing file", config.Settings{"dir": {dir}, "browse": {"false"}}, "/sub/file.txt", http.StatusOK, "file"},
+		{"strip", config.Settings{"dir": {dir}, "strip": {"/static"}}, "/static/sub/file.txt", http.StatusOK, "file"},
+		{"strip mismatch", config.Settings{"dir": {dir}, "strip": {"/static"}}, "/sub/file.txt", http.StatusNotFound, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			handler, err := newFSHandler(test.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if rec.Code != test.code {
+				t.Fatalf("got %d, want %d", rec.Code, test.code)
+			}
+			if test.body != "" && rec.Body.String() != test.body {
+				t.Fatalf("got %q, want %q", rec.Body.String(), test.body)
+			}
+		})
+	}
+}
+
+func TestFSFileAndDir(t *testing.T) {
+	_, err := newFSHandler(config.Settings{"dir": {"."}, "file": {"main.go"}})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
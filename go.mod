@@ -1,13 +1,14 @@
 module github.com/dvob/http-server
 
-go 1.23
+go 1.23.0
 
 require (
 	github.com/felixge/httpsnoop v1.0.4
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.3
 	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.32.0
+	golang.org/x/time v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	golang.org/x/net v0.32.0 // indirect
-	golang.org/x/text v0.21.0 // indirect
-)
+require golang.org/x/text v0.21.0 // indirect
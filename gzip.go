@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/dvob/http-server/config"
+)
+
+// gzipMiddleware compresses the response with gzip if the client accepts it.
+// The compression level can be set with the setting level (1-9).
+func gzipMiddleware(config config.Settings) (middleware, error) {
+	level, err := config.Int("level", gzip.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if level != gzip.DefaultCompression && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return nil, fmt.Errorf("invalid level '%d': must be between %d and %d", level, gzip.BestSpeed, gzip.BestCompression)
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(r.Header.Values("Accept-Encoding")) {
+				next(w, r)
+				return
+			}
+
+			gw := &gzipResponseWriter{
+				ResponseWriter: w,
+				level:          level,
+			}
+			defer gw.close()
+			next(gw, r)
+		}
+	}, nil
+}
+
+// acceptsGzip reports whether the Accept-Encoding values allow gzip. An
+// explicit gzip entry takes precedence over the wildcard *, e.g. gzip;q=0, *
+// does not allow gzip.
+func acceptsGzip(values []string) bool {
+	gzipSeen, gzipAccepted := false, false
+	wildcardAccepted := false
+	for _, value := range values {
+		for _, encoding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(encoding, ";")
+			name = strings.TrimSpace(name)
+			if name != "gzip" && name != "*" {
+				continue
+			}
+			q := strings.ReplaceAll(params, " ", "")
+			accepted := !(q == "q=0" || strings.HasPrefix(q, "q=0.") && strings.Trim(q[4:], "0") == "")
+			if name == "gzip" {
+				gzipSeen = true
+				gzipAccepted = gzipAccepted || accepted
+			} else {
+				wildcardAccepted = wildcardAccepted || accepted
+			}
+		}
+	}
+	if gzipSeen {
+		return gzipAccepted
+	}
+	return wildcardAccepted
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	level       int
+	gz          *gzip.Writer
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	// responses without body, partial responses (Content-Range refers to the
+	// uncompressed content) and already encoded responses are sent as is
+	if code != http.StatusNoContent && code != http.StatusNotModified && code != http.StatusPartialContent && g.Header().Get("Content-Encoding") == "" {
+		g.Header().Del("Content-Length")
+		g.Header().Set("Content-Encoding", "gzip")
+		// the level has been validated in the factory
+		g.gz, _ = gzip.NewWriterLevel(g.ResponseWriter, g.level)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	g.WriteHeader(http.StatusOK)
+	if g.gz == nil {
+		return g.ResponseWriter.Write(p)
+	}
+	return g.gz.Write(p)
+}
+
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// Hijack is implemented explicitly (instead of through Unwrap) so that
+// close does not write to a hijacked connection.
+func (g *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(g.ResponseWriter).Hijack()
+	if err == nil {
+		g.hijacked = true
+	}
+	return conn, buf, err
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// close writes the gzip footer. If the handler did not write anything the
+// response is an empty gzip stream.
+func (g *gzipResponseWriter) close() {
+	if g.hijacked {
+		return
+	}
+	g.WriteHeader(http.StatusOK)
+	if g.gz != nil {
+		g.gz.Close()
+	}
+}
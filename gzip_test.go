@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvob/http-server/config"
+)
+
+func TestGzip(t *testing.T) {
+	mw, err := gzipMiddleware(config.Settings{"level": {"9"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name           string
+		handler        http.HandlerFunc
+		acceptEncoding string
+		gzip           bool
+		body           string
+	}{
+		{
+			name:           "data",
+			handler:        dataHandler,
+			acceptEncoding: "gzip, deflate",
+			gzip:           true,
+			body:           strings.Repeat("A", 100),
+		},
+		{
+			name:           "empty",
+			handler:        func(w http.ResponseWriter, r *http.Request) {},
+			acceptEncoding: "gzip",
+			gzip:           true,
+			body:           "",
+		},
+		{
+			name:           "not accepted",
+			handler:        dataHandler,
+			acceptEncoding: "gzip;q=0, br",
+			gzip:           false,
+			body:           strings.Repeat("A", 100),
+		},
+		{
+			name:           "wildcard",
+			handler:        dataHandler,
+			acceptEncoding: "br, *",
+			gzip:           true,
+			body:           strings.Repeat("A", 100),
+		},
+		{
+			name:           "not accepted with wildcard",
+			handler:        dataHandler,
+			acceptEncoding: "gzip;q=0, *",
+			gzip:           false,
+			body:           strings.Repeat("A", 100),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/?size=100", nil)
+			req.Header.Set("Accept-Encoding", test.acceptEncoding)
+			rec := httptest.NewRecorder()
+			mw(test.handler)(rec, req)
+
+			var body io.Reader = rec.Body
+			if test.gzip {
+				if rec.Header().Get("Content-Encoding") != "gzip" {
+					t.Fatalf("missing Content-Encoding: gzip: %v", rec.Header())
+				}
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = gz
+			} else if rec.Header().Get("Content-Encoding") != "" {
+				t.Fatalf("unexpected Content-Encoding %q", rec.Header().Get("Content-Encoding"))
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.body {
+				t.Fatalf("got body %q, want %q", got, test.body)
+			}
+		})
+	}
+
+	_, err = gzipMiddleware(config.Settings{"level": {"10"}})
+	if err == nil {
+		t.Fatal("expected error for invalid level")
+	}
+}
+
+func TestGzipPartialContent(t *testing.T) {
+	mw, err := gzipMiddleware(config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := mw(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.txt", time.Time{}, strings.NewReader(strings.Repeat("A", 100)))
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Range", "bytes=0-9")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("unexpected Content-Encoding %q", rec.Header().Get("Content-Encoding"))
+	}
+	if rec.Body.String() != strings.Repeat("A", 10) || rec.Header().Get("Content-Range") != "bytes 0-9/100" {
+		t.Fatalf("got body %q with Content-Range %q", rec.Body.String(), rec.Header().Get("Content-Range"))
+	}
+}
+
+// hijackRecorder is a ResponseRecorder which supports hijacking and records
+// if the header is written after the connection has been hijacked.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked         bool
+	writeAfterHijack bool
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, peer := net.Pipe()
+	go io.Copy(io.Discard, peer)
+	h.hijacked = true
+	return conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)), nil
+}
+
+func (h *hijackRecorder) WriteHeader(code int) {
+	if h.hijacked {
+		h.writeAfterHijack = true
+	}
+	h.ResponseRecorder.WriteHeader(code)
+}
+
+func TestGzipHijack(t *testing.T) {
+	mw, err := gzipMiddleware(config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncate, err := newTruncateHandler(config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	mw(truncate.ServeHTTP)(rec, req)
+	if !rec.hijacked {
+		t.Fatal("connection was not hijacked")
+	}
+	if rec.writeAfterHijack {
+		t.Fatal("header written after hijack")
+	}
+}
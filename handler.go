@@ -2,111 +2,185 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dvob/http-server/config"
 )
 
-type handlerFactory func(map[string]string) (http.Handler, error)
+type handlerFactory func(config.Settings) (http.Handler, error)
 
 func noConfigFactory(handler http.HandlerFunc) handlerFactory {
-	return func(_ map[string]string) (http.Handler, error) {
+	return func(_ config.Settings) (http.Handler, error) {
 		return handler, nil
 	}
 }
 
 // TODO: use register and move init logic to handler
 var handlers = map[string]handlerFactory{
-	"info": noConfigFactory(infoHandler),
-	"static": func(config map[string]string) (http.Handler, error) {
+	"info": newInfoHandler,
+	"static": func(config config.Settings) (http.Handler, error) {
 		handler := newStaticResponseHandler()
-		if body, ok := config["body"]; ok {
+		if body, ok := config.Lookup("body"); ok {
 			handler.body = []byte(body)
 		}
-		if code, ok := config["code"]; ok {
+		if code, ok := config.Lookup("code"); ok {
 			num, err := strconv.Atoi(code)
 			if err != nil {
 				return nil, fmt.Errorf("invalid status code '%s'", code)
 			}
 			handler.code = num
 		}
-
-		return handler, nil
-	},
-	"echo": noConfigFactory(echoHandler),
-	"proxy": func(config map[string]string) (http.Handler, error) {
-		target, ok := config["target"]
-		if !ok {
-			return nil, fmt.Errorf("missing configuration 'target'")
+		if reason, ok := config.Lookup("reason"); ok {
+			handler.reason = reason
 		}
-
-		targetURL, err := url.Parse(target)
+		isTemplate, err := config.Bool("template", false)
 		if err != nil {
 			return nil, err
 		}
-
-		rewriteFunc := func(pr *httputil.ProxyRequest) {
-			pr.SetURL(targetURL)
-			pr.SetXForwarded()
-			// pr.Out.Host = pr.In.Host
+		if isTemplate {
+			handler.template, err = template.New("static").Parse(string(handler.body))
+			if err != nil {
+				return nil, fmt.Errorf("invalid template: %w", err)
+			}
 		}
 
-		// prepare reverse proxy for HTTP/1.1
-		http11Transport := http.DefaultTransport.(*http.Transport).Clone()
-		http11Transport.ForceAttemptHTTP2 = false
-		http11Transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
-		http11Transport.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+		return withContentHeaders(config, handler), nil
+	},
+	"echo":  newEchoHandler,
+	"proxy": newProxyHandler,
+	"hec":   noConfigFactory(hecHandler),
+	"data": func(config config.Settings) (http.Handler, error) {
+		fill := config.Get("fill")
+		return withContentHeaders(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveData(w, r, fill)
+		})), nil
+	},
+	"fs":            newFSHandler,
+	"redirect-loop": newRedirectLoopHandler,
+	"validate":      newValidateHandler,
+	"truncate":      newTruncateHandler,
+	"ndjson":        newNDJSONHandler,
+	"client-cert":   noConfigFactory(clientCertHandler),
+	"payload":       newPayloadHandler,
+	"client-hello":  noConfigFactory(clientHelloHandler),
+	"healthz":       noConfigFactory(healthzHandler),
+	"template":      newTemplateHandler,
+	"sse":           newSSEHandler,
+	"random":        newRandomHandler,
+	"header-mirror": func(config config.Settings) (http.Handler, error) {
+		return newHeaderMirrorHandler(headerList(config.List("allow")), headerList(config.List("deny"))), nil
+	},
+}
 
-		http11Upstream := &httputil.ReverseProxy{
-			Rewrite:   rewriteFunc,
-			Transport: http11Transport,
+// withContentHeaders sets additional response headers of the content serving
+// handlers:
+//   - filename: sets Content-Disposition to attachment
+//   - link: adds a Link header for each value (e.g. preload hints)
+func withContentHeaders(config config.Settings, next http.Handler) http.Handler {
+	header := http.Header{}
+	if filename, ok := config.Lookup("filename"); ok {
+		header.Set("Content-Disposition", attachmentHeader(filename))
+	}
+	for _, link := range config["link"] {
+		header.Add("Link", link)
+	}
+	if len(header) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for key, values := range header {
+			w.Header()[key] = append(w.Header()[key], values...)
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		// prepare default reverse proxy which uses HTTP/2 if the upstream supports it
-		defaultTransport := http.DefaultTransport.(*http.Transport).Clone()
-		defaultTransport.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
+// attachmentHeader returns a Content-Disposition value for filename. Non-ASCII
+// filenames are encoded according to RFC 5987 with an ASCII fallback for
+// older clients.
+func attachmentHeader(filename string) string {
+	fallback := strings.Builder{}
+	ascii := true
+	for _, r := range filename {
+		switch {
+		case r > unicode.MaxASCII || !unicode.IsPrint(r):
+			ascii = false
+			fallback.WriteByte('_')
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		default:
+			fallback.WriteRune(r)
 		}
-		defaultUpstream := &httputil.ReverseProxy{
-			Rewrite:   rewriteFunc,
-			Transport: defaultTransport,
+	}
+	value := fmt.Sprintf("attachment; filename=\"%s\"", fallback.String())
+	if !ascii {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return value
+}
+
+func encodeRFC5987(s string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	out := strings.Builder{}
+	for _, b := range []byte(s) {
+		if b < unicode.MaxASCII && (unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b)) || strings.IndexByte(attrChars, b) >= 0) {
+			out.WriteByte(b)
+			continue
 		}
+		fmt.Fprintf(&out, "%%%02X", b)
+	}
+	return out.String()
+}
 
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Upgrade is only supported by HTTP/1.1
-			if r.Proto == "HTTP/1.1" && r.Header.Get("Upgrade") != "" {
-				http11Upstream.ServeHTTP(w, r)
-			} else {
-				defaultUpstream.ServeHTTP(w, r)
-			}
-		}), nil
-	},
-	"hec":  noConfigFactory(hecHandler),
-	"data": noConfigFactory(dataHandler),
-	"fs": func(config map[string]string) (http.Handler, error) {
-		file, ok := config["file"]
-		if !ok {
-			return nil, fmt.Errorf("missing configuration 'file'")
-		}
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			http.ServeFile(w, r, file)
-		}), nil
-	},
+// newInfoHandler returns the info handler. With the setting include-body the
+// request body up to the size max-body-size (default 64KB) is included.
+func newInfoHandler(config config.Settings) (http.Handler, error) {
+	includeBody, err := config.Bool("include-body", false)
+	if err != nil {
+		return nil, err
+	}
+	maxBodySize, err := config.Int("max-body-size", 64<<10)
+	if err != nil {
+		return nil, err
+	}
+	if !includeBody {
+		return http.HandlerFunc(infoHandler), nil
+	}
+	if maxBodySize <= 0 {
+		return nil, fmt.Errorf("invalid max-body-size '%d': must be greater than zero", maxBodySize)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveInfo(w, r, maxBodySize)
+	}), nil
 }
 
 func infoHandler(w http.ResponseWriter, r *http.Request) {
+	serveInfo(w, r, 0)
+}
+
+// serveInfo responds with information about the request. If maxBodySize is
+// greater than zero the body is included up to this size.
+func serveInfo(w http.ResponseWriter, r *http.Request, maxBodySize int) {
 	w.Header().Add("Content-Type", "application/json")
 	info := struct {
 		Hostname    string               `json:"hostname,omitempty"`
@@ -116,6 +190,13 @@ func infoHandler(w http.ResponseWriter, r *http.Request) {
 	}{}
 	info.Hostname, _ = os.Hostname()
 	info.Request = newRequest(r)
+	if maxBodySize > 0 {
+		err := info.Request.readBody(r.Body, maxBodySize)
+		if err != nil {
+			httpError(w, "failed to read body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	info.TLS = r.TLS
 	info.JWTMetaData = make(map[string][]*jwt)
 	for header, values := range r.Header {
@@ -136,6 +217,60 @@ func infoHandler(w http.ResponseWriter, r *http.Request) {
 type staticResponseHandler struct {
 	body []byte
 	code int
+	// reason is an optional custom reason phrase for the status line. Since
+	// Go always uses the standard reason phrases the response is written
+	// directly to the hijacked connection. This only works for HTTP/1.x.
+	reason string
+	// template renders the body with the templateData of the request if set
+	template *template.Template
+}
+
+// templateData is available in templates rendered by the handlers.
+type templateData struct {
+	// Nonce is set by the nonce middleware
+	Nonce  string
+	Method string
+	Host   string
+	Path   string
+	Header http.Header
+	Query  url.Values
+	// PathParams are the wildcards of the path pattern (e.g. /users/{id})
+	PathParams map[string]string
+}
+
+func newTemplateData(r *http.Request) *templateData {
+	return &templateData{
+		Nonce:      nonceFromContext(r.Context()),
+		Method:     r.Method,
+		Host:       r.Host,
+		Path:       r.URL.Path,
+		Header:     r.Header,
+		Query:      r.URL.Query(),
+		PathParams: pathParams(r),
+	}
+}
+
+// pathParams returns the values of the wildcards in the pattern which matched
+// the request.
+func pathParams(r *http.Request) map[string]string {
+	params := map[string]string{}
+	pattern := r.Pattern
+	for {
+		start := strings.IndexByte(pattern, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(pattern[start:], '}')
+		if end < 0 {
+			break
+		}
+		name := strings.TrimSuffix(pattern[start+1:start+end], "...")
+		if name != "$" {
+			params[name] = r.PathValue(name)
+		}
+		pattern = pattern[start+end+1:]
+	}
+	return params
 }
 
 func newStaticResponseHandler() *staticResponseHandler {
@@ -146,14 +281,415 @@ func newStaticResponseHandler() *staticResponseHandler {
 }
 
 func (s *staticResponseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := s.body
+	if s.template != nil {
+		buf := &bytes.Buffer{}
+		err := s.template.Execute(buf, newTemplateData(r))
+		if err != nil {
+			httpError(w, "failed to render template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body = buf.Bytes()
+	}
+
+	if s.reason != "" {
+		err := s.writeRaw(w, r, body)
+		if err == nil {
+			return
+		}
+		log.Printf("failed to write custom status line: %s", err)
+	}
 	w.WriteHeader(s.code)
-	w.Write(s.body)
+	w.Write(body)
+}
+
+// writeRaw writes the response including the custom status line to the
+// hijacked connection.
+func (s *staticResponseHandler) writeRaw(w http.ResponseWriter, r *http.Request, body []byte) error {
+	if r.ProtoMajor != 1 {
+		return fmt.Errorf("custom reason phrase is not supported with %s", r.Proto)
+	}
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	header := w.Header().Clone()
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	header.Set("Connection", "close")
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(body))
+	}
+
+	fmt.Fprintf(buf, "HTTP/1.%d %03d %s\r\n", r.ProtoMinor, s.code, s.reason)
+	header.Write(buf)
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Flush()
+}
+
+// headerList returns the canonical header names of a list of headers.
+func headerList(list []string) []string {
+	headers := []string{}
+	for _, header := range list {
+		headers = append(headers, http.CanonicalHeaderKey(header))
+	}
+	return headers
+}
+
+// headerMirrorHandler returns the request headers as response headers with
+// the prefix X-Echo-.
+type headerMirrorHandler struct {
+	allow []string
+	deny  []string
+}
+
+func newHeaderMirrorHandler(allow, deny []string) *headerMirrorHandler {
+	return &headerMirrorHandler{
+		allow: allow,
+		deny:  deny,
+	}
+}
+
+func (h *headerMirrorHandler) mirror(header string) bool {
+	if len(h.allow) > 0 && !slices.Contains(h.allow, header) {
+		return false
+	}
+	return !slices.Contains(h.deny, header)
+}
+
+func (h *headerMirrorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for key, values := range r.Header {
+		if !h.mirror(key) {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add("X-Echo-"+key, value)
+		}
+	}
+	// Go removes the Host header from r.Header
+	if r.Host != "" && h.mirror("Host") {
+		w.Header().Set("X-Echo-Host", r.Host)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// redirectLoopHandler redirects to itself until the request has been
+// redirected count times. The number of redirects is tracked in the query
+// parameter hop.
+type redirectLoopHandler struct {
+	count int
+	code  int
+}
+
+func newRedirectLoopHandler(config config.Settings) (http.Handler, error) {
+	count, err := config.Int("count", 5)
+	if err != nil {
+		return nil, err
+	}
+	code, err := config.Int("code", http.StatusFound)
+	if err != nil {
+		return nil, err
+	}
+	if code < 300 || code > 399 {
+		return nil, fmt.Errorf("invalid redirect code '%d'", code)
+	}
+	return &redirectLoopHandler{
+		count: count,
+		code:  code,
+	}, nil
+}
+
+func (h *redirectLoopHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	hop := 0
+	if rawHop := query.Get("hop"); rawHop != "" {
+		var err error
+		hop, err = strconv.Atoi(rawHop)
+		if err != nil {
+			httpError(w, "invalid hop: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if hop >= h.count {
+		fmt.Fprintf(w, "redirected %d times\n", hop)
+		return
+	}
+
+	query.Set("hop", strconv.Itoa(hop+1))
+	target := url.URL{
+		Path:     r.URL.Path,
+		RawQuery: query.Encode(),
+	}
+	http.Redirect(w, r, target.String(), h.code)
+}
+
+// truncateHandler announces declaredSize bytes in the Content-Length header
+// but only sends actualSize bytes and then closes the connection.
+type truncateHandler struct {
+	declaredSize int
+	actualSize   int
+}
+
+func newTruncateHandler(config config.Settings) (http.Handler, error) {
+	declaredSize, err := config.Int("declared-size", 1024)
+	if err != nil {
+		return nil, err
+	}
+	actualSize, err := config.Int("actual-size", declaredSize/2)
+	if err != nil {
+		return nil, err
+	}
+	if actualSize < 0 || actualSize >= declaredSize {
+		return nil, fmt.Errorf("actual-size must be between 0 and declared-size")
+	}
+	return &truncateHandler{
+		declaredSize: declaredSize,
+		actualSize:   actualSize,
+	}, nil
+}
+
+func (t *truncateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// HTTP/2 does not support hijacking. we abort the stream instead.
+	if r.ProtoMajor != 1 {
+		w.Header().Set("Content-Length", strconv.Itoa(t.declaredSize))
+		io.Copy(w, newNBytesReader(t.actualSize))
+		http.NewResponseController(w).Flush()
+		panic(http.ErrAbortHandler)
+	}
+
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(buf, "HTTP/1.%d 200 OK\r\n", r.ProtoMinor)
+	fmt.Fprintf(buf, "Content-Length: %d\r\n", t.declaredSize)
+	buf.WriteString("Content-Type: application/octet-stream\r\n\r\n")
+	_, err = io.Copy(buf, newNBytesReader(t.actualSize))
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	err = buf.Flush()
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// ndjsonHandler streams count JSON objects, one per line. The objects are
+// rendered from template which has access to the sequence number (.Seq).
+type ndjsonHandler struct {
+	count    int
+	interval time.Duration
+	template *template.Template
+}
+
+func newNDJSONHandler(config config.Settings) (http.Handler, error) {
+	count, err := config.Int("count", 10)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := config.Duration("interval", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	rawTemplate := `{"seq":{{ .Seq }}}`
+	if t, ok := config.Lookup("template"); ok {
+		rawTemplate = t
+	}
+	tmpl, err := template.New("ndjson").Parse(rawTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	return &ndjsonHandler{
+		count:    count,
+		interval: interval,
+		template: tmpl,
+	}, nil
+}
+
+func (n *ndjsonHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	rc := http.NewResponseController(w)
+	line := &bytes.Buffer{}
+	for seq := 0; seq < n.count; seq++ {
+		if seq > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(n.interval):
+			}
+		}
+
+		line.Reset()
+		err := n.template.Execute(line, struct{ Seq int }{seq})
+		if err != nil {
+			log.Printf("failed to render ndjson template: %s", err)
+			return
+		}
+		// each object has to be on a single line
+		compact := bytes.ReplaceAll(line.Bytes(), []byte("\n"), nil)
+		compact = append(compact, '\n')
+		_, err = w.Write(compact)
+		if err != nil {
+			return
+		}
+		err = rc.Flush()
+		if err != nil {
+			log.Printf("failed to flush: %s", err)
+		}
+	}
+}
+
+// sseHandler streams server-sent events (data: <n>) every interval. With
+// count 0 events are sent until the client disconnects.
+type sseHandler struct {
+	count    int
+	interval time.Duration
+}
+
+func newSSEHandler(config config.Settings) (http.Handler, error) {
+	count, err := config.Int("count", 0)
+	if err != nil {
+		return nil, err
+	}
+	interval, err := config.Duration("interval", time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid interval '%s': must be positive", interval)
+	}
+	return &sseHandler{
+		count:    count,
+		interval: interval,
+	}, nil
+}
+
+func (s *sseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	rc := http.NewResponseController(w)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for seq := 0; s.count == 0 || seq < s.count; seq++ {
+		if seq > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+
+		_, err := fmt.Fprintf(w, "data: %d\n\n", seq)
+		if err != nil {
+			return
+		}
+		err = rc.Flush()
+		if err != nil {
+			log.Printf("failed to flush: %s", err)
+			return
+		}
+	}
+}
+
+// healthzHandler responds with 200 until the graceful shutdown of the server
+// has started. Then it responds with 503 so that the server gets removed from
+// load balancers (e.g. by a Kubernetes readiness probe).
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if shuttingDown.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, `{"status":"shutting down"}`)
+		return
+	}
+	io.WriteString(w, `{"status":"ok"}`)
 }
 
 func echoHandler(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, r.Body)
 }
 
+// newEchoHandler returns the echo handler. With the setting normalize
+// (json or form) form bodies (urlencoded and multipart) are parsed and written
+// back in a normalized form. Other bodies are echoed as is.
+func newEchoHandler(config config.Settings) (http.Handler, error) {
+	normalize := config.Get("normalize")
+	if normalize == "" {
+		return http.HandlerFunc(echoHandler), nil
+	}
+	if normalize != "json" && normalize != "form" {
+		return nil, fmt.Errorf("invalid normalize '%s'", normalize)
+	}
+	maxSize, err := config.Int("max-size", 10<<20)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType != "application/x-www-form-urlencoded" && mediaType != "multipart/form-data" {
+			echoHandler(w, r)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, int64(maxSize))
+		var err error
+		if mediaType == "multipart/form-data" {
+			err = r.ParseMultipartForm(int64(maxSize))
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			httpError(w, "failed to parse form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if normalize == "form" {
+			w.Header().Set("Content-Type", "application/x-www-form-urlencoded")
+			io.WriteString(w, r.PostForm.Encode())
+			return
+		}
+
+		form := echoForm{
+			Fields: r.PostForm,
+		}
+		if r.MultipartForm != nil && len(r.MultipartForm.File) > 0 {
+			form.Files = map[string][]echoFile{}
+			for field, headers := range r.MultipartForm.File {
+				for _, h := range headers {
+					form.Files[field] = append(form.Files[field], echoFile{
+						Filename:    h.Filename,
+						ContentType: h.Header.Get("Content-Type"),
+						Size:        h.Size,
+					})
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(form)
+	}), nil
+}
+
+type echoForm struct {
+	Fields url.Values            `json:"fields"`
+	Files  map[string][]echoFile `json:"files,omitempty"`
+}
+
+type echoFile struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
 func hecHandler(w http.ResponseWriter, r *http.Request) {
 	scanner := bufio.NewScanner(r.Body)
 	for scanner.Scan() {
@@ -176,26 +712,147 @@ func hecHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func dataHandler(w http.ResponseWriter, r *http.Request) {
+	serveData(w, r, "")
+}
+
+// serveData responds with size bytes (query parameter). The content is
+// selected with the query parameter fill or defaultFill if it is not set. The
+// Content-Type is application/octet-stream unless the query parameter
+// content-type is set.
+func serveData(w http.ResponseWriter, r *http.Request, defaultFill string) {
 	var err error
 	sizeStr := r.URL.Query().Get("size")
 	size := 0
 	if sizeStr != "" {
 		size, err = strconv.Atoi(sizeStr)
 		if err != nil {
-			http.Error(w, "invalid size: "+err.Error(), 400)
+			httpError(w, "invalid size: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if size < 0 {
+			httpError(w, "invalid size: must not be negative", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rateStr := r.URL.Query().Get("rate")
+	rate := 0
+	if rateStr != "" {
+		rate, err = strconv.Atoi(rateStr)
+		if err != nil || rate <= 0 {
+			httpError(w, "invalid rate: must be a positive number of bytes per second", http.StatusBadRequest)
 			return
 		}
 	}
 
-	_, err = io.Copy(w, newNBytesReader(size))
+	fill := defaultFill
+	if r.URL.Query().Has("fill") {
+		fill = r.URL.Query().Get("fill")
+	}
+	data := newNBytesReaderFill(size, parseFill(fill))
+
+	contentType := "application/octet-stream"
+	if ct := r.URL.Query().Get("content-type"); ct != "" {
+		contentType = ct
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+
+	if rate > 0 {
+		err = throttledCopy(r.Context(), w, data, rate)
+	} else {
+		_, err = io.Copy(w, data)
+	}
 	if err != nil {
 		log.Print(err)
 	}
 }
 
+// throttledCopy copies src to w with rate bytes per second. The data is
+// written in chunks of a tenth of the rate and flushed after each chunk. It
+// stops as soon as ctx is done.
+func throttledCopy(ctx context.Context, w http.ResponseWriter, src io.Reader, rate int) error {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, min(max(rate/10, 1), 32*1024))
+	start := time.Now()
+	sent := 0
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			_, writeErr := w.Write(buf[:n])
+			if writeErr != nil {
+				return writeErr
+			}
+			rc.Flush()
+			sent += n
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// wait until the sent bytes are due according to the rate
+		wait := time.Until(start.Add(time.Duration(sent) * time.Second / time.Duration(rate)))
+		if wait <= 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
 func newNBytesReader(size int) *nBytesReader {
+	return newNBytesReaderFill(size, fillA)
+}
+
+func newNBytesReaderFill(size int, fill fillFunc) *nBytesReader {
 	return &nBytesReader{
-		n: size,
+		n:    size,
+		fill: fill,
+	}
+}
+
+// fillFunc fills p with data. offset is the number of bytes which have been
+// filled before, which allows to continue a pattern across multiple calls.
+type fillFunc func(p []byte, offset int)
+
+var fillA = patternFill([]byte("A"))
+
+// parseFill returns the fill for the name: A (default), zero, random (from
+// crypto/rand) or any other string which is repeated.
+func parseFill(name string) fillFunc {
+	switch name {
+	case "", "A":
+		return fillA
+	case "zero":
+		return func(p []byte, _ int) {
+			clear(p)
+		}
+	case "random":
+		return func(p []byte, _ int) {
+			cryptorand.Read(p)
+		}
+	default:
+		return patternFill([]byte(name))
+	}
+}
+
+// patternFill repeats pattern. For performance the pattern is copied into
+// the buffer in chunks of about 32KB.
+func patternFill(pattern []byte) fillFunc {
+	chunk := bytes.Repeat(pattern, max(1, 32*1024/len(pattern)))
+	return func(p []byte, offset int) {
+		start := offset % len(pattern)
+		for len(p) > 0 {
+			n := copy(p, chunk[start:])
+			p = p[n:]
+			start = 0
+		}
 	}
 }
 
@@ -204,18 +861,23 @@ type nBytesReader struct {
 	n int
 	// already returned bytes
 	sent int
+	fill fillFunc
 }
 
 func (n *nBytesReader) Read(p []byte) (int, error) {
-	sent := 0
-	for ; sent < len(p) && n.sent < n.n; sent++ {
-		p[sent] = 'A'
-		n.sent++
+	remaining := n.n - n.sent
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+	if len(p) > remaining {
+		p = p[:remaining]
 	}
+	n.fill(p, n.sent)
+	n.sent += len(p)
 	if n.sent == n.n {
-		return sent, io.EOF
+		return len(p), io.EOF
 	}
-	return sent, nil
+	return len(p), nil
 }
 
 type request struct {
@@ -225,9 +887,36 @@ type request struct {
 	Protocol   string      `json:"protocol"`
 	Header     http.Header `json:"header"`
 	RemoteAddr string      `json:"remote_addr"`
+	// Body is only set if the body is included. Bodies which are not valid
+	// UTF-8 are base64 encoded (BodyEncoding base64).
+	Body          string `json:"body,omitempty"`
+	BodyEncoding  string `json:"body_encoding,omitempty"`
+	BodyTruncated bool   `json:"body_truncated,omitempty"`
 	// TLS evtl.
 }
 
+// readBody reads body up to maxSize bytes into r.
+func (r *request) readBody(body io.Reader, maxSize int) error {
+	if body == nil {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(body, int64(maxSize)+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxSize {
+		data = data[:maxSize]
+		r.BodyTruncated = true
+	}
+	if utf8.Valid(data) {
+		r.Body = string(data)
+		return nil
+	}
+	r.Body = base64.StdEncoding.EncodeToString(data)
+	r.BodyEncoding = "base64"
+	return nil
+}
+
 func newRequest(r *http.Request) *request {
 	return &request{
 		Method:     r.Method,
@@ -240,11 +929,15 @@ func newRequest(r *http.Request) *request {
 }
 
 type jwt struct {
-	Header   map[string]any `json:"header,omitempty"`
-	Claims   map[string]any `json:"claims,omitempty"`
-	Expiry   time.Time      `json:"expiry,omitempty"`
-	IssuedAt time.Time      `json:"issued_at,omitempty"`
-	Error    string         `json:"error,omitempty"`
+	Header      map[string]any `json:"header,omitempty"`
+	Claims      map[string]any `json:"claims,omitempty"`
+	Expiry      time.Time      `json:"expiry,omitempty"`
+	IssuedAt    time.Time      `json:"issued_at,omitempty"`
+	NotBefore   time.Time      `json:"not_before,omitempty"`
+	Expired     bool           `json:"expired"`
+	NotYetValid bool           `json:"not_yet_valid"`
+	Verified    bool           `json:"verified,omitempty"`
+	Error       string         `json:"error,omitempty"`
 }
 
 func readJWT(tokenHeader string) *jwt {
@@ -288,6 +981,101 @@ func readJWT(tokenHeader string) *jwt {
 		iatTime, _ := iat.(float64)
 		jwt.IssuedAt = time.Unix(int64(iatTime), 0)
 	}
+	if nbf, ok := jwt.Claims["nbf"]; ok {
+		nbfTime, _ := nbf.(float64)
+		jwt.NotBefore = time.Unix(int64(nbfTime), 0)
+	}
+
+	// exp and nbf are checked with a leeway to tolerate clock skew
+	now := time.Now()
+	if !jwt.Expiry.IsZero() {
+		jwt.Expired = now.After(jwt.Expiry.Add(jwtLeeway))
+	}
+	if !jwt.NotBefore.IsZero() {
+		jwt.NotYetValid = now.Add(jwtLeeway).Before(jwt.NotBefore)
+	}
+
+	if jwtKey != nil {
+		alg, _ := jwt.Header["alg"].(string)
+		err = jwtKey.verify(alg, token)
+		if err != nil {
+			jwt.Error = "verification failed: " + err.Error()
+		} else {
+			jwt.Verified = true
+		}
+	}
 
 	return jwt
 }
+
+// randomHandler responds with a status code chosen randomly from codes. The
+// codes are weighted by weights.
+type randomHandler struct {
+	codes   []int
+	weights []float64
+	total   float64
+	rand    *lockedRand
+}
+
+// newRandomHandler reads the list of status codes (e.g. codes: "200,404,500")
+// and the optional list of weights (e.g. weights: "8,1,1"). Without weights all
+// codes are equally likely. With the setting seed the sequence of codes is
+// reproducible independently of other randomized behavior.
+func newRandomHandler(config config.Settings) (http.Handler, error) {
+	h := &randomHandler{
+		rand: rng,
+	}
+	for _, code := range config.List("codes") {
+		c, err := strconv.Atoi(code)
+		if err != nil || c < 100 || c > 599 {
+			return nil, fmt.Errorf("invalid status code '%s'", code)
+		}
+		h.codes = append(h.codes, c)
+	}
+	if len(h.codes) == 0 {
+		return nil, fmt.Errorf("missing configuration 'codes'")
+	}
+
+	weights := config.List("weights")
+	if len(weights) == 0 {
+		weights = slices.Repeat([]string{"1"}, len(h.codes))
+	}
+	if len(weights) != len(h.codes) {
+		return nil, fmt.Errorf("got %d weights for %d codes", len(weights), len(h.codes))
+	}
+	for _, weight := range weights {
+		w, err := strconv.ParseFloat(weight, 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight '%s': must be a non-negative number", weight)
+		}
+		h.weights = append(h.weights, w)
+		h.total += w
+	}
+	if h.total == 0 {
+		return nil, fmt.Errorf("invalid weights: at least one weight has to be greater than zero")
+	}
+
+	if seedStr, ok := config.Lookup("seed"); ok {
+		seed, err := strconv.ParseUint(seedStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seed '%s'", seedStr)
+		}
+		h.rand = newLockedRand(seed)
+	}
+	return h, nil
+}
+
+func (h *randomHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code := h.codes[len(h.codes)-1]
+	n := h.rand.Float64() * h.total
+	for i, weight := range h.weights {
+		if n < weight {
+			code = h.codes[i]
+			break
+		}
+		n -= weight
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "{\"code\":%d}\n", code)
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvob/http-server/config"
+)
+
+func TestHeaderMirror(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		settings config.Settings
+		expected http.Header
+	}{
+		{
+			name:     "all",
+			settings: config.Settings{},
+			expected: http.Header{
+				"X-Echo-Host": {"example.com"},
+				"X-Echo-Foo":  {"a", "b"},
+				"X-Echo-Bar":  {"c"},
+			},
+		},
+		{
+			name:     "allow",
+			settings: config.Settings{"allow": {"foo"}},
+			expected: http.Header{
+				"X-Echo-Foo": {"a", "b"},
+			},
+		},
+		{
+			name:     "deny",
+			settings: config.Settings{"deny": {"foo,host"}},
+			expected: http.Header{
+				"X-Echo-Bar": {"c"},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			h, err := handlers["header-mirror"](test.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			req.Header.Add("Foo", "a")
+			req.Header.Add("Foo", "b")
+			req.Header.Add("Bar", "c")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if !reflect.DeepEqual(rec.Header(), test.expected) {
+				t.Fatalf("got %v, want %v", rec.Header(), test.expected)
+			}
+		})
+	}
+}
+
+func TestAttachmentHeader(t *testing.T) {
+	for _, test := range []struct {
+		filename string
+		expected string
+	}{
+		{"data.bin", `attachment; filename="data.bin"`},
+		{`a"b.txt`, `attachment; filename="a\"b.txt"`},
+		{"grüezi wohl.txt", `attachment; filename="gr_ezi wohl.txt"; filename*=UTF-8''gr%C3%BCezi%20wohl.txt`},
+	} {
+		got := attachmentHeader(test.filename)
+		if got != test.expected {
+			t.Errorf("got %s, want %s", got, test.expected)
+		}
+	}
+}
+
+func TestStaticFilename(t *testing.T) {
+	h, err := handlers["static"](config.Settings{"filename": {"foo.txt"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	expected := `attachment; filename="foo.txt"`
+	if got := rec.Header().Get("Content-Disposition"); got != expected {
+		t.Fatalf("got %s, want %s", got, expected)
+	}
+}
+
+func TestStaticReason(t *testing.T) {
+	h, err := handlers["static"](config.Settings{"code": {"299"}, "reason": {"Totally Fine"}, "body": {"foo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "299 Totally Fine" {
+		t.Fatalf("got status %q, want %q", resp.Status, "299 Totally Fine")
+	}
+	if string(body) != "foo" {
+		t.Fatalf("got body %q, want %q", body, "foo")
+	}
+}
+
+func TestRedirectLoop(t *testing.T) {
+	for _, test := range []struct {
+		count   string
+		success bool
+	}{
+		{"3", true},
+		{"9", true},
+		{"10", false},
+	} {
+		h, err := handlers["redirect-loop"](config.Settings{"count": {test.count}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv := httptest.NewServer(h)
+
+		// the default client stops after 10 requests
+		resp, err := http.Get(srv.URL + "/loop")
+		srv.Close()
+		if !test.success {
+			if err == nil {
+				resp.Body.Close()
+				t.Fatalf("count %s: expected redirect limit error", test.count)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("count %s: %s", test.count, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Request.URL.Query().Get("hop") != test.count {
+			t.Fatalf("count %s: got %d after %s hops", test.count, resp.StatusCode, resp.Request.URL.Query().Get("hop"))
+		}
+	}
+}
+
+func TestStaticLink(t *testing.T) {
+	links := []string{
+		"</style.css>; rel=preload; as=style",
+		"</app.js>; rel=preload; as=script",
+	}
+	h, err := handlers["static"](config.Settings{"link": links})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Values("Link"); !reflect.DeepEqual(got, links) {
+		t.Fatalf("got %v, want %v", got, links)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	h, err := handlers["truncate"](config.Settings{"declared-size": {"1000"}, "actual-size": {"100"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength != 1000 {
+		t.Fatalf("got Content-Length %d, want 1000", resp.ContentLength)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if len(body) != 100 {
+		t.Fatalf("got %d bytes, want 100", len(body))
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	h, err := handlers["ndjson"](config.Settings{
+		"count":    {"5"},
+		"interval": {"1ms"},
+		"template": {`{"id": {{ .Seq }}, "name": "item"}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatalf("got Content-Type %q", ct)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for i := 0; ; i++ {
+		item := struct {
+			ID int `json:"id"`
+		}{}
+		err := decoder.Decode(&item)
+		if err == io.EOF {
+			if i != 5 {
+				t.Fatalf("got %d lines, want 5", i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item.ID != i {
+			t.Fatalf("got id %d, want %d", item.ID, i)
+		}
+	}
+}
+
+func TestEchoNormalize(t *testing.T) {
+	handler, err := newEchoHandler(config.Settings{"normalize": {"json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("b=2&a=1&a=x+y"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	form := echoForm{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &form); err != nil {
+		t.Fatal(err)
+	}
+	expected := url.Values{"a": {"1", "x y"}, "b": {"2"}}
+	if !reflect.DeepEqual(form.Fields, expected) {
+		t.Fatalf("got %v, want %v", form.Fields, expected)
+	}
+
+	handler, err = newEchoHandler(config.Settings{"normalize": {"form"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("b=2&a=x+y"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Body.String() != "a=x+y&b=2" {
+		t.Fatalf("got %q, want %q", rec.Body.String(), "a=x+y&b=2")
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	defer shuttingDown.Store(false)
+
+	for _, test := range []struct {
+		shuttingDown bool
+		code         int
+		body         string
+	}{
+		{false, http.StatusOK, `{"status":"ok"}`},
+		{true, http.StatusServiceUnavailable, `{"status":"shutting down"}`},
+	} {
+		shuttingDown.Store(test.shuttingDown)
+		rec := httptest.NewRecorder()
+		healthzHandler(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if rec.Code != test.code || rec.Body.String() != test.body {
+			t.Errorf("got %d %q, want %d %q", rec.Code, rec.Body.String(), test.code, test.body)
+		}
+	}
+}
+
+func TestSSE(t *testing.T) {
+	h, err := handlers["sse"](config.Settings{"count": {"3"}, "interval": {"1ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got Content-Type %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "data: 0\n\ndata: 1\n\ndata: 2\n\n" {
+		t.Fatalf("unexpected body %q", body)
+	}
+}
+
+func TestSSEClientDisconnect(t *testing.T) {
+	h, err := handlers["sse"](config.Settings{"interval": {"1ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "data: 0\n" {
+		t.Fatalf("got %q %v", line, err)
+	}
+	resp.Body.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not return after client disconnected")
+	}
+}
+
+func TestDataRate(t *testing.T) {
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	dataHandler(rec, httptest.NewRequest(http.MethodGet, "/?size=1000&rate=4000", nil))
+	elapsed := time.Since(start)
+	if rec.Body.Len() != 1000 {
+		t.Fatalf("got %d bytes, want 1000", rec.Body.Len())
+	}
+	// the first chunk is sent immediately, the last one after 200ms
+	if elapsed < 150*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("took %s, want about 200ms", elapsed)
+	}
+
+	rec = httptest.NewRecorder()
+	dataHandler(rec, httptest.NewRequest(http.MethodGet, "/?size=1000&rate=0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got %d for invalid rate, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDataRateClientDisconnect(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(done)
+		dataHandler(w, r)
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/?size=1000000&rate=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = resp.Body.Read(make([]byte, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not return after client disconnected")
+	}
+}
+
+func TestDataFill(t *testing.T) {
+	h, err := handlers["data"](config.Settings{"fill": {"xy"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		url      string
+		expected string
+	}{
+		{"/?size=5", "xyxyx"},
+		{"/?size=5&fill=zero", "\x00\x00\x00\x00\x00"},
+		{"/?size=5&fill=A", "AAAAA"},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Body.String() != test.expected {
+			t.Errorf("%s: got %q, want %q", test.url, rec.Body.String(), test.expected)
+		}
+	}
+}
+
+func TestDataHeaders(t *testing.T) {
+	for _, test := range []struct {
+		url         string
+		contentType string
+	}{
+		{"/?size=1000", "application/octet-stream"},
+		{"/?size=1000&content-type=text/plain", "text/plain"},
+	} {
+		rec := httptest.NewRecorder()
+		dataHandler(rec, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if got := rec.Header().Get("Content-Type"); got != test.contentType {
+			t.Errorf("%s: got Content-Type %q, want %q", test.url, got, test.contentType)
+		}
+		if got := rec.Header().Get("Content-Length"); got != "1000" {
+			t.Errorf("%s: got Content-Length %q, want 1000", test.url, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	dataHandler(rec, httptest.NewRequest(http.MethodGet, "/?size=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("negative size: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if got := rec.Header().Get("Content-Length"); got == "-1" {
+		t.Errorf("negative size: got Content-Length %q", got)
+	}
+}
+
+func TestInfoBody(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		settings config.Settings
+		body     io.Reader
+		expected request
+	}{
+		{"disabled", config.Settings{}, strings.NewReader("hello"), request{}},
+		{"text", config.Settings{"include-body": {"true"}}, strings.NewReader("hello"), request{Body: "hello"}},
+		{"empty", config.Settings{"include-body": {"true"}}, nil, request{}},
+		{"binary", config.Settings{"include-body": {"true"}}, strings.NewReader("\xff\x00"), request{Body: "/wA=", BodyEncoding: "base64"}},
+		{"truncated", config.Settings{"include-body": {"true"}, "max-body-size": {"3"}}, strings.NewReader("hello"), request{Body: "hel", BodyTruncated: true}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			handler, err := handlers["info"](test.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", test.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("got %d, want %d", rec.Code, http.StatusOK)
+			}
+			info := struct {
+				Request request `json:"request"`
+			}{}
+			err = json.NewDecoder(rec.Body).Decode(&info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := request{Body: info.Request.Body, BodyEncoding: info.Request.BodyEncoding, BodyTruncated: info.Request.BodyTruncated}
+			if !reflect.DeepEqual(got, test.expected) {
+				t.Fatalf("got %+v, want %+v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestRandom(t *testing.T) {
+	run := func() map[int]int {
+		handler, err := handlers["random"](config.Settings{"codes": {"200,404,500"}, "weights": {"8,2,0"}, "seed": {"1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts := map[int]int{}
+		for i := 0; i < 1000; i++ {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			expected := fmt.Sprintf("{\"code\":%d}\n", rec.Code)
+			if rec.Body.String() != expected {
+				t.Fatalf("got body %q, want %q", rec.Body.String(), expected)
+			}
+			counts[rec.Code]++
+		}
+		return counts
+	}
+
+	counts := run()
+	if counts[500] != 0 {
+		t.Errorf("got %d responses with weight 0", counts[500])
+	}
+	if counts[200] < 700 || counts[200] > 900 {
+		t.Errorf("got %d responses with 200, want about 800", counts[200])
+	}
+	if !reflect.DeepEqual(counts, run()) {
+		t.Error("got different codes for the same seed")
+	}
+
+	for _, settings := range []config.Settings{
+		{},
+		{"codes": {"200,abc"}},
+		{"codes": {"200,404"}, "weights": {"1"}},
+		{"codes": {"200"}, "weights": {"-1"}},
+		{"codes": {"200"}, "weights": {"0"}},
+	} {
+		if _, err := handlers["random"](settings); err == nil {
+			t.Errorf("%v: expected error", settings)
+		}
+	}
+}
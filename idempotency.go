@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dvob/http-server/config"
+)
+
+// idempotency returns the cached response for requests with an already seen
+// idempotency key (header Idempotency-Key) instead of calling the next
+// handler again. Concurrent requests with the same key wait for the first
+// request to complete.
+func idempotency(config config.Settings) (middleware, error) {
+	ttl, err := config.Duration("ttl", time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	header := "Idempotency-Key"
+	if h, ok := config.Lookup("header"); ok {
+		header = h
+	}
+	cache := &idempotencyCache{
+		ttl:     ttl,
+		entries: map[string]*idempotencyEntry{},
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(header)
+			if key == "" {
+				next(w, r)
+				return
+			}
+
+			entry, isNew := cache.get(key)
+			if !isNew {
+				<-entry.done
+				if entry.failed {
+					httpError(w, "request with the same idempotency key failed", http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				entry.response.writeTo(w)
+				return
+			}
+
+			completed := false
+			defer func() {
+				// the handler panicked. the entry is removed so that the
+				// request can be retried
+				if !completed {
+					entry.failed = true
+					cache.remove(key, entry)
+				}
+				close(entry.done)
+			}()
+			entry.response = newBufferedResponse()
+			next(entry.response, r)
+			entry.expires = time.Now().Add(cache.ttl)
+			completed = true
+			entry.response.writeTo(w)
+		}
+	}, nil
+}
+
+type idempotencyCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	// done is closed as soon as response and expires are set
+	done     chan struct{}
+	response *bufferedResponse
+	expires  time.Time
+	// failed is set if the handler did not complete
+	failed bool
+}
+
+// get returns the entry of key. If there is no valid entry a new entry is
+// created and isNew is true.
+func (c *idempotencyCache) get(key string) (entry *idempotencyEntry, isNew bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := c.entries[key]
+	if ok && (!isClosed(entry.done) || now.Before(entry.expires)) {
+		return entry, false
+	}
+
+	// remove expired entries
+	for k, e := range c.entries {
+		if isClosed(e.done) && !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	entry = &idempotencyEntry{
+		done: make(chan struct{}),
+	}
+	c.entries[key] = entry
+	return entry, true
+}
+
+// remove removes the entry of key if it is still entry.
+func (c *idempotencyCache) remove(key string, entry *idempotencyEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries[key] == entry {
+		delete(c.entries, key)
+	}
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// bufferedResponse is a http.ResponseWriter which keeps the response in
+// memory.
+type bufferedResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{
+		header: http.Header{},
+	}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for key, values := range b.header {
+		w.Header()[key] = values
+	}
+	code := b.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	w.WriteHeader(code)
+	w.Write(b.body.Bytes())
+}
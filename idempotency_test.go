@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dvob/http-server/config"
+)
+
+func TestIdempotency(t *testing.T) {
+	var calls atomic.Int64
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("X-Call", fmt.Sprint(n))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "call %d", n)
+	}
+	mw, err := idempotency(config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mw(handler)
+
+	do := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	wg := sync.WaitGroup{}
+	responses := make([]*httptest.ResponseRecorder, 5)
+	for i := range responses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = do("key1")
+		}()
+	}
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("handler called %d times, want 1", calls.Load())
+	}
+	for _, rec := range responses {
+		if rec.Code != http.StatusCreated || rec.Body.String() != "call 1" || rec.Header().Get("X-Call") != "1" {
+			t.Fatalf("got %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+		}
+	}
+
+	if rec := do("key2"); rec.Body.String() != "call 2" {
+		t.Fatalf("got %q, want call 2", rec.Body.String())
+	}
+	if rec := do(""); rec.Body.String() != "call 3" {
+		t.Fatalf("got %q, want call 3", rec.Body.String())
+	}
+}
+
+func TestIdempotencyPanic(t *testing.T) {
+	var calls atomic.Int64
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			panic("first call fails")
+		}
+		fmt.Fprint(w, "ok")
+	}
+	mw, err := idempotency(config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := mw(handler)
+
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.Header.Set("Idempotency-Key", "key")
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		return rec
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic")
+			}
+		}()
+		do()
+	}()
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- do() }()
+	select {
+	case rec := <-done:
+		if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Fatalf("got %d %q, want 200 ok", rec.Code, rec.Body.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("retry after a panic blocks")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// jwtKey is used to verify the signature of JWTs in readJWT. If it is nil the
+// signature is not verified.
+var jwtKey *jwtVerificationKey
+
+// jwtLeeway is the tolerated clock skew when the exp and nbf claims are
+// checked.
+var jwtLeeway = time.Minute
+
+// jwtVerificationKey is either an RSA public key or a HMAC secret.
+type jwtVerificationKey struct {
+	rsa    *rsa.PublicKey
+	secret []byte
+}
+
+// loadJWTKey reads the key from file. If the file contains a PEM encoded
+// public key it is used for RS* tokens, otherwise the content of the file
+// without surrounding whitespace is used as secret for HS* tokens.
+func loadJWTKey(file string) (*jwtVerificationKey, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT key: %w", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return &jwtVerificationKey{secret: bytes.TrimSpace(content)}, nil
+	}
+
+	var pub any
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			pub = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block '%s' in JWT key", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT key: %w", err)
+	}
+	rsaKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported JWT key type %T", pub)
+	}
+	return &jwtVerificationKey{rsa: rsaKey}, nil
+}
+
+// verify verifies the signature of token (header.claims.signature) with the
+// algorithm alg.
+func (k *jwtVerificationKey) verify(alg, token string) error {
+	signingInput, encodedSignature, ok := cutLast(token, ".")
+	if !ok {
+		return fmt.Errorf("missing signature")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	var hash crypto.Hash
+	switch alg[min(len(alg), 2):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm '%s'", alg)
+	}
+
+	switch {
+	case strings.HasPrefix(alg, "HS"):
+		if k.secret == nil {
+			return fmt.Errorf("algorithm '%s' requires a secret but the key is a public key", alg)
+		}
+		mac := hmac.New(hash.New, k.secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return fmt.Errorf("signature mismatch")
+		}
+		return nil
+	case strings.HasPrefix(alg, "RS"):
+		if k.rsa == nil {
+			return fmt.Errorf("algorithm '%s' requires a public key but the key is a secret", alg)
+		}
+		h := hash.New()
+		h.Write([]byte(signingInput))
+		return rsa.VerifyPKCS1v15(k.rsa, hash, h.Sum(nil), signature)
+	default:
+		return fmt.Errorf("unsupported algorithm '%s'", alg)
+	}
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+	return s[:i], s[i+len(sep):], true
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func signTestJWT(t *testing.T, alg string, sign func(signingInput string) []byte) string {
+	t.Helper()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + alg + `","typ":"JWT"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"test"}`))
+	signingInput := header + "." + claims
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sign(signingInput))
+}
+
+func TestReadJWTVerify(t *testing.T) {
+	defer func() { jwtKey = nil }()
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	rsaKeyFile := filepath.Join(dir, "rsa.pem")
+	secretFile := filepath.Join(dir, "secret")
+	for file, content := range map[string][]byte{rsaKeyFile: pubPEM, secretFile: []byte("secret\n")} {
+		if err := os.WriteFile(file, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	signRS256 := func(signingInput string) []byte {
+		h := sha256.Sum256([]byte(signingInput))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, h[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	signHS256 := func(secret []byte) func(string) []byte {
+		return func(signingInput string) []byte {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write([]byte(signingInput))
+			return mac.Sum(nil)
+		}
+	}
+	rs256 := signTestJWT(t, "RS256", signRS256)
+	hs256 := signTestJWT(t, "HS256", signHS256([]byte("secret")))
+
+	for _, test := range []struct {
+		name     string
+		keyFile  string
+		token    string
+		verified bool
+	}{
+		{"rs256", rsaKeyFile, rs256, true},
+		{"rs256 tampered", rsaKeyFile, rs256[:len(rs256)-4] + "AAAA", false},
+		{"hs256", secretFile, hs256, true},
+		{"hs256 wrong secret", secretFile, signTestJWT(t, "HS256", signHS256([]byte("other"))), false},
+		// the public key must not be accepted as HMAC secret
+		{"algorithm confusion", rsaKeyFile, signTestJWT(t, "HS256", signHS256(pubPEM)), false},
+		{"none", secretFile, signTestJWT(t, "none", func(string) []byte { return nil }), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			jwtKey, err = loadJWTKey(test.keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jwt := readJWT("Bearer " + test.token)
+			if jwt == nil {
+				t.Fatal("token not detected")
+			}
+			if jwt.Verified != test.verified {
+				t.Fatalf("got verified %t, want %t (error: %s)", jwt.Verified, test.verified, jwt.Error)
+			}
+			if !test.verified && jwt.Error == "" {
+				t.Fatal("missing error for failed verification")
+			}
+		})
+	}
+}
+
+func TestReadJWTValidity(t *testing.T) {
+	now := time.Now().Unix()
+	for _, test := range []struct {
+		name        string
+		claims      string
+		expired     bool
+		notYetValid bool
+	}{
+		{"valid", fmt.Sprintf(`{"exp":%d,"nbf":%d}`, now+3600, now-3600), false, false},
+		{"expired", fmt.Sprintf(`{"exp":%d}`, now-3600), true, false},
+		{"expired within leeway", fmt.Sprintf(`{"exp":%d}`, now-10), false, false},
+		{"not yet valid", fmt.Sprintf(`{"nbf":%d}`, now+3600), false, true},
+		{"not yet valid within leeway", fmt.Sprintf(`{"nbf":%d}`, now+10), false, false},
+		{"no claims", `{}`, false, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			token := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + base64.RawURLEncoding.EncodeToString([]byte(test.claims)) + "."
+			jwt := readJWT(token)
+			if jwt == nil {
+				t.Fatal("token not detected")
+			}
+			if jwt.Expired != test.expired || jwt.NotYetValid != test.notYetValid {
+				t.Fatalf("got expired=%t not_yet_valid=%t, want expired=%t not_yet_valid=%t", jwt.Expired, jwt.NotYetValid, test.expired, test.notYetValid)
+			}
+		})
+	}
+}
@@ -1,22 +1,38 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/dvob/http-server/config"
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/netutil"
 )
 
 type serverConfig struct {
 	addr              string
+	iface             string
+	unixSocket        string
 	readTimeout       time.Duration
 	readHeaderTimeout time.Duration
 	writeTimeout      time.Duration
@@ -24,33 +40,76 @@ type serverConfig struct {
 	maxHeaderBytes    int
 	tlsConfig         tlsConfig
 	connLog           bool
+	connLogDetail     bool
+	maxRequests       int
+	maxConns          int
+	maxInFlight       int
+	expvar            bool
+	metrics           bool
+	metricsAddr       string
+	pprof             bool
+	pprofAddr         string
+	shutdownTimeout   time.Duration
+	shutdownEndpoint  bool
+	h2c               bool
+	proxyProtocol     bool
 }
 
 func newDefaultServer() serverConfig {
 	return serverConfig{
-		tlsConfig: newDefaultTLSConfig(),
-		addr:      ":8080",
+		tlsConfig:       newDefaultTLSConfig(),
+		addr:            ":8080",
+		shutdownTimeout: 10 * time.Second,
 	}
 }
 
 func (s *serverConfig) bindFlags(fs *flag.FlagSet) {
 	fs.StringVar(&s.addr, "addr", s.addr, "listen address.")
+	fs.StringVar(&s.unixSocket, "unix", s.unixSocket, "listen on this unix domain socket instead of -addr")
+	fs.StringVar(&s.iface, "interface", s.iface, "listen on the address of this network interface (e.g. eth0). the port is taken from -addr")
 	fs.DurationVar(&s.readTimeout, "read-timeout", s.readTimeout, "read timeout")
 	fs.DurationVar(&s.readHeaderTimeout, "read-header-timeout", s.readHeaderTimeout, "read header timeout")
 	fs.DurationVar(&s.writeTimeout, "write-timeout", s.writeTimeout, "write timeout")
 	fs.DurationVar(&s.idleTimeout, "idle-timeout", s.idleTimeout, "idle timeout")
 	fs.BoolVar(&s.connLog, "conn-log", s.connLog, "enable connection log")
+	fs.BoolVar(&s.connLogDetail, "conn-log-detail", s.connLogDetail, "enable connection log with the age, number of requests and close reason of each connection")
+	fs.IntVar(&s.maxConns, "max-conns", s.maxConns, "maximum number of concurrent connections. further connections are not accepted until a connection is closed (0 means unlimited)")
+	fs.IntVar(&s.maxInFlight, "max-in-flight", s.maxInFlight, "maximum number of concurrently handled requests. further requests are answered with 503 (0 means unlimited)")
+	fs.IntVar(&s.maxRequests, "max-requests", s.maxRequests, "shut down the server after handling this number of requests (0 means unlimited)")
+	fs.BoolVar(&s.expvar, "expvar", s.expvar, "publish request and connection counters on /debug/vars")
+	fs.BoolVar(&s.metrics, "metrics", s.metrics, "serve request counters of the log middleware in the Prometheus format on /metrics")
+	fs.StringVar(&s.metricsAddr, "metrics-addr", s.metricsAddr, "serve /metrics on this address instead of the main listener (implies -metrics)")
+	fs.BoolVar(&s.pprof, "pprof", s.pprof, "serve the profiling endpoints of net/http/pprof on /debug/pprof/")
+	fs.StringVar(&s.pprofAddr, "pprof-addr", s.pprofAddr, "serve /debug/pprof/ on this address instead of the main listener (implies -pprof)")
+	fs.BoolVar(&s.proxyProtocol, "proxy-protocol", s.proxyProtocol, "expect the PROXY protocol header (v1 or v2) on each connection and use its client address as remote address")
+	fs.BoolVar(&s.h2c, "h2c", s.h2c, "serve HTTP/2 without TLS (h2c) on the plaintext listener")
+	fs.BoolVar(&s.shutdownEndpoint, "enable-shutdown-endpoint", s.shutdownEndpoint, "start the graceful shutdown on POST /shutdown. only requests from loopback addresses are allowed")
+	fs.DurationVar(&s.shutdownTimeout, "shutdown-timeout", s.shutdownTimeout, "on SIGINT or SIGTERM wait up to this duration for active requests to complete before the server is stopped")
 	s.tlsConfig.bindFlags(fs)
 }
 
 func (s *serverConfig) getServer() (*http.Server, error) {
+	if s.unixSocket != "" && s.tlsConfig.hosts != "" {
+		return nil, fmt.Errorf("-unix can not be used with -tls-hosts since ACME requires a public TCP port")
+	}
+
 	tlsConfig, err := s.tlsConfig.getConfig()
 	if err != nil {
 		return nil, err
 	}
+	if s.h2c && tlsConfig != nil {
+		return nil, fmt.Errorf("-h2c can not be used with TLS which already provides HTTP/2 via ALPN")
+	}
 
 	var connStateFn func(net.Conn, http.ConnState)
-	if s.connLog {
+	if s.connLogDetail {
+		// the server uses the read timeout if no idle timeout is set
+		idleTimeout := s.idleTimeout
+		if idleTimeout == 0 {
+			idleTimeout = s.readTimeout
+		}
+		connStateFn = newConnTracker(idleTimeout).connState
+	} else if s.connLog {
 		connStateFn = func(c net.Conn, s http.ConnState) {
 			if s == http.StateIdle || s == http.StateActive {
 				return
@@ -65,8 +124,25 @@ func (s *serverConfig) getServer() (*http.Server, error) {
 		}
 	}
 
+	if s.expvar {
+		connStateFn = withConnState(stats.connState, connStateFn)
+	}
+
+	if tlsConfig != nil {
+		tlsConfig.GetConfigForClient = clientHellos.getConfigForClient(tlsConfig.GetConfigForClient)
+		connStateFn = withConnState(clientHellos.connState, connStateFn)
+	}
+
+	addr := s.addr
+	if s.iface != "" {
+		addr, err = interfaceAddr(s.iface, s.addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	srv := &http.Server{
-		Addr:              s.addr,
+		Addr:              addr,
 		TLSConfig:         tlsConfig,
 		ReadTimeout:       s.readTimeout,
 		ReadHeaderTimeout: s.readHeaderTimeout,
@@ -84,56 +160,595 @@ func (s *serverConfig) run(handler http.Handler) error {
 		return err
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// shutdown starts the graceful shutdown like SIGTERM
+	ctx, shutdown := context.WithCancel(ctx)
+	defer shutdown()
+
+	if s.shutdownEndpoint {
+		handler = withShutdownEndpoint(handler, shutdown)
+	}
+
+	if s.expvar {
+		handler = stats.handler(handler)
+	}
+	if s.maxInFlight > 0 {
+		handler = limitInFlight(s.maxInFlight, handler)
+	}
+
+	// additional listeners which are closed together with the server
+	extraServers := []*http.Server{}
+	if s.metrics || s.metricsAddr != "" {
+		metrics = newRequestMetrics()
+		if s.metricsAddr == "" {
+			handler = metrics.handler(handler)
+		} else {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics)
+			extraServers = append(extraServers, startExtraServer("metrics", s.metricsAddr, mux))
+		}
+	}
+	if s.pprof || s.pprofAddr != "" {
+		if s.pprofAddr == "" {
+			handler = withPprof(handler)
+		} else {
+			extraServers = append(extraServers, startExtraServer("pprof", s.pprofAddr, withPprof(http.NotFoundHandler())))
+		}
+	}
+
 	srv.Handler = handler
+	if s.maxRequests > 0 {
+		srv.Handler = shutdownAfter(s.maxRequests, shutdown, handler)
+	}
+	if s.h2c {
+		srv.Handler = withH2C(srv.Handler)
+	}
+
+	redirectSrv := s.tlsConfig.getRedirectServer(srv.Addr)
+	if redirectSrv != nil {
+		go func() {
+			err := redirectSrv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("HTTP redirect listener failed: %s", err)
+			}
+		}()
+	}
+
+	conns := &activeConns{}
+	srv.ConnState = withConnState(conns.connState, srv.ConnState)
 
+	stopped := make(chan struct{})
+	shutdownDone := shutdownOnDone(ctx, stopped, srv, s.shutdownTimeout, conns)
+
+	l, err := s.listen(srv.Addr)
+	if err != nil {
+		return err
+	}
 	if srv.TLSConfig == nil {
-		return srv.ListenAndServe()
+		err = srv.Serve(l)
 	} else {
 		// certificates are explicitly configured in the TLSConfig
-		return srv.ListenAndServeTLS("", "")
+		err = srv.ServeTLS(l, "", "")
+	}
+	close(stopped)
+	<-shutdownDone
+	if redirectSrv != nil {
+		redirectSrv.Close()
+	}
+	for _, extraSrv := range extraServers {
+		extraSrv.Close()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// limitInFlight answers requests with 503 if n requests are already being
+// handled.
+func limitInFlight(n int, next http.Handler) http.Handler {
+	sem := make(chan struct{}, n)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+		default:
+			httpError(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer func() { <-sem }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// startExtraServer serves handler on addr in the background. The program
+// exits if the listener fails.
+func startExtraServer(name, addr string, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		err := srv.ListenAndServe()
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("%s listener failed: %s", name, err)
+		}
+	}()
+	return srv
+}
+
+// withH2C serves HTTP/2 with prior knowledge or upgrade on a plaintext
+// listener and passes all other requests to next.
+func withH2C(next http.Handler) http.Handler {
+	return h2c.NewHandler(next, &http2.Server{})
+}
+
+// withPprof serves the net/http/pprof handlers on /debug/pprof/ and all other
+// requests with next.
+func withPprof(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/debug/pprof/") {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listen listens on the unix socket or on the TCP address addr. With
+// -proxy-protocol the client address is read from the PROXY protocol header.
+// With -max-conns the number of accepted connections is limited. Further
+// connections wait in the accept queue of the kernel.
+func (s *serverConfig) listen(addr string) (net.Listener, error) {
+	var (
+		l   net.Listener
+		err error
+	)
+	if s.unixSocket != "" {
+		l, err = listenUnix(s.unixSocket)
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.proxyProtocol {
+		l = newProxyProtoListener(l)
+	}
+	if s.maxConns > 0 {
+		l = netutil.LimitListener(l, s.maxConns)
+	}
+	return l, nil
+}
+
+// listenUnix listens on the unix domain socket path. A stale socket file
+// from a previous run is removed first.
+func listenUnix(path string) (*net.UnixListener, error) {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode().Type() != os.ModeSocket {
+			return nil, fmt.Errorf("can not listen on %s: file exists and is not a socket", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(true)
+	return l, nil
+}
+
+// shuttingDown is set as soon as the graceful shutdown of the server has
+// started.
+var shuttingDown atomic.Bool
+
+// shutdownOnDone gracefully shuts down srv as soon as ctx is done. Active
+// requests get up to timeout to complete. If stopped is closed before ctx is
+// done nothing happens. The returned channel is closed when the shutdown is
+// complete.
+func shutdownOnDone(ctx context.Context, stopped <-chan struct{}, srv *http.Server, timeout time.Duration, conns *activeConns) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+			return
+		}
+		log.Printf("shutting down, active connections: %d", conns.count.Load())
+		shuttingDown.Store(true)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		err := srv.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Printf("graceful shutdown failed: %s", err)
+			srv.Close()
+		}
+	}()
+	return done
+}
+
+// withShutdownEndpoint calls shutdown on POST /shutdown and passes all other
+// requests to next. Only requests from loopback addresses or over a unix
+// domain socket are allowed.
+func withShutdownEndpoint(next http.Handler, shutdown func()) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/shutdown" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !isLocalRequest(r) {
+			httpError(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httpError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		log.Printf("shutdown requested by %s", r.RemoteAddr)
+		shuttingDown.Store(true)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, `{"status":"shutting down"}`)
+		shutdown()
+	})
+}
+
+// isLocalRequest reports whether r comes from a loopback address or over a
+// unix domain socket. With the PROXY protocol the remote address of a unix
+// socket connection is the address from the header and is checked as such.
+func isLocalRequest(r *http.Request) bool {
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	_, isUnix := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr)
+	return isUnix
+}
+
+// activeConns counts the open connections of a server.
+type activeConns struct {
+	count atomic.Int64
+}
+
+func (a *activeConns) connState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		a.count.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		a.count.Add(-1)
+	}
+}
+
+// withConnState returns a ConnState function which calls fn and then next
+// if it is not nil.
+func withConnState(fn, next func(net.Conn, http.ConnState)) func(net.Conn, http.ConnState) {
+	if next == nil {
+		return fn
+	}
+	return func(c net.Conn, state http.ConnState) {
+		fn(c, state)
+		next(c, state)
+	}
+}
+
+// interfaceAddr returns the listen address consisting of an address of the
+// network interface name and the port of addr. IPv4 addresses are preferred
+// and link-local addresses are ignored.
+func interfaceAddr(name, addr string) (string, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid address '%s': %w", addr, err)
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", fmt.Errorf("interface %s: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to get addresses of interface %s: %w", name, err)
+	}
+
+	var ip net.IP
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			ip = ipNet.IP
+			break
+		}
+		if ip == nil {
+			ip = ipNet.IP
+		}
+	}
+	if ip == nil {
+		return "", fmt.Errorf("interface %s has no usable address", name)
 	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// shutdownAfter calls shutdown once n requests have been handled. shutdown
+// has to start the graceful shutdown of the server (see shutdownOnDone).
+// Requests beyond n are rejected until the listener is closed.
+func shutdownAfter(n int, shutdown func(), next http.Handler) http.Handler {
+	var count atomic.Int64
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := count.Add(1)
+		if current > int64(n) {
+			w.Header().Set("Connection", "close")
+			httpError(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+		if current == int64(n) {
+			log.Printf("handled %d requests, shutting down", n)
+			shuttingDown.Store(true)
+			shutdown()
+		}
+	})
 }
 
 type tlsConfig struct {
-	cert     string
-	key      string
-	hosts    string
-	cacheDir string
+	certs             stringList
+	keys              stringList
+	certDir           string
+	selfSigned        bool
+	selfSignedHosts   string
+	hosts             string
+	cacheDir          string
+	renewBefore       time.Duration
+	acmeDirectory     string
+	acmeEmail         string
+	httpChallenge     bool
+	httpChallengeAddr string
+	redirect          bool
+	redirectAddr      string
+	clientCA          string
+	clientAuth        string
+	minVersion        string
+	maxVersion        string
+	ciphers           string
+
+	// acmeManager is set by getConfig if ACME is enabled
+	acmeManager *autocert.Manager
 }
 
 func newDefaultTLSConfig() tlsConfig {
 	return tlsConfig{
-		cacheDir: "cert-dir",
+		cacheDir:          "cert-dir",
+		httpChallengeAddr: ":80",
+		redirectAddr:      ":80",
+		selfSignedHosts:   "localhost,127.0.0.1",
 	}
 }
 
 func (t *tlsConfig) bindFlags(fs *flag.FlagSet) {
-	fs.StringVar(&t.cert, "tls-cert", t.cert, "path to PEM encodeded certificate")
-	fs.StringVar(&t.key, "tls-key", t.key, "path to PEM encodeded key")
+	fs.Var(&t.certs, "tls-cert", "path to PEM encodeded certificate. can be repeated together with -tls-key to serve multiple certificates selected by SNI")
+	fs.Var(&t.keys, "tls-key", "path to PEM encodeded key")
+	fs.StringVar(&t.certDir, "tls-cert-dir", t.certDir, "load all certificate pairs <name>.crt and <name>.key from this directory. the certificate is selected by SNI")
+	fs.BoolVar(&t.selfSigned, "tls-self-signed", t.selfSigned, "serve an in-memory self-signed certificate for -tls-self-signed-hosts")
+	fs.StringVar(&t.selfSignedHosts, "tls-self-signed-hosts", t.selfSignedHosts, "comma-separated list of hostnames and IPs for the self-signed certificate")
 	fs.StringVar(&t.hosts, "tls-hosts", t.hosts, "enables automatic certificate management with ACME (Let's Encrypt) for the specified list of comma-seperated hostnames")
 	fs.StringVar(&t.cacheDir, "tls-cache-dir", t.cacheDir, "cache dir for ACME certificates")
+	fs.DurationVar(&t.renewBefore, "tls-renew-before", t.renewBefore, "renew ACME certificates this long before they expire (0 uses the autocert default of 30 days)")
+	fs.StringVar(&t.acmeDirectory, "tls-acme-directory", t.acmeDirectory, "ACME directory URL (e.g. https://acme-staging-v02.api.letsencrypt.org/directory). defaults to Let's Encrypt production")
+	fs.StringVar(&t.acmeEmail, "tls-acme-email", t.acmeEmail, "contact email for the ACME account registration")
+	fs.BoolVar(&t.httpChallenge, "tls-http-challenge", t.httpChallenge, "serve the ACME HTTP-01 challenge on -tls-http-challenge-addr. all other requests on this address are redirected to HTTPS")
+	fs.StringVar(&t.httpChallengeAddr, "tls-http-challenge-addr", t.httpChallengeAddr, "listen address for the ACME HTTP-01 challenge")
+	fs.BoolVar(&t.redirect, "tls-redirect", t.redirect, "redirect all HTTP requests on -tls-redirect-addr to HTTPS. with -tls-hosts the ACME HTTP-01 challenge is served as well")
+	fs.StringVar(&t.redirectAddr, "tls-redirect-addr", t.redirectAddr, "listen address for -tls-redirect")
+	fs.StringVar(&t.clientCA, "tls-client-ca", t.clientCA, "path to PEM encoded CA certificates to verify client certificates (mTLS)")
+	fs.StringVar(&t.clientAuth, "tls-client-auth", t.clientAuth, "client certificate mode: request, require (any certificate) or verify (against -tls-client-ca). defaults to verify if -tls-client-ca is set")
+	fs.StringVar(&t.minVersion, "tls-min-version", t.minVersion, "minimum TLS version (1.0, 1.1, 1.2 or 1.3)")
+	fs.StringVar(&t.maxVersion, "tls-max-version", t.maxVersion, "maximum TLS version (1.0, 1.1, 1.2 or 1.3)")
+	fs.StringVar(&t.ciphers, "tls-ciphers", t.ciphers, "comma-separated list of allowed cipher suites (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256). applies to TLS 1.0 to 1.2 only")
 }
 
 func (t *tlsConfig) getConfig() (*tls.Config, error) {
+	tlsConfig, err := t.getCertificateConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		if t.clientCA != "" || t.clientAuth != "" {
+			return nil, fmt.Errorf("client certificates require TLS (-tls-cert/-tls-key or -tls-hosts)")
+		}
+		if t.redirect {
+			return nil, fmt.Errorf("-tls-redirect requires TLS")
+		}
+		if t.minVersion != "" || t.maxVersion != "" || t.ciphers != "" {
+			return nil, fmt.Errorf("-tls-min-version, -tls-max-version and -tls-ciphers require TLS (-tls-cert/-tls-key or -tls-hosts)")
+		}
+		return nil, nil
+	}
+	if t.redirect && t.httpChallenge {
+		return nil, fmt.Errorf("-tls-redirect can not be used with -tls-http-challenge which already redirects to HTTPS")
+	}
+	err = t.setClientAuth(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = t.setProtocol(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// setProtocol configures the allowed TLS versions and cipher suites.
+func (t *tlsConfig) setProtocol(tlsConfig *tls.Config) error {
+	var err error
+	if t.minVersion != "" {
+		tlsConfig.MinVersion, err = parseTLSVersion(t.minVersion)
+		if err != nil {
+			return err
+		}
+	}
+	if t.maxVersion != "" {
+		tlsConfig.MaxVersion, err = parseTLSVersion(t.maxVersion)
+		if err != nil {
+			return err
+		}
+	}
+	if tlsConfig.MinVersion != 0 && tlsConfig.MaxVersion != 0 && tlsConfig.MinVersion > tlsConfig.MaxVersion {
+		return fmt.Errorf("-tls-min-version %s is greater than -tls-max-version %s", t.minVersion, t.maxVersion)
+	}
+	if t.ciphers != "" {
+		tlsConfig.CipherSuites, err = parseCipherSuites(t.ciphers)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("invalid TLS version '%s', valid options: 1.0, 1.1, 1.2, 1.3", version)
+	}
+	return v, nil
+}
+
+// parseCipherSuites parses a comma-separated list of cipher suite names as
+// returned by tls.CipherSuiteName.
+func parseCipherSuites(list string) ([]uint16, error) {
+	suites := map[string]uint16{}
+	names := []string{}
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[suite.Name] = suite.ID
+		names = append(names, suite.Name)
+	}
+	ids := []uint16{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := suites[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid cipher suite '%s', valid options: %s", name, strings.Join(names, ", "))
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// setClientAuth configures the verification of client certificates.
+func (t *tlsConfig) setClientAuth(tlsConfig *tls.Config) error {
+	mode := t.clientAuth
+	if mode == "" && t.clientCA != "" {
+		mode = "verify"
+	}
+	switch mode {
+	case "":
+		return nil
+	case "request":
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	case "require":
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+	case "verify":
+		if t.clientCA == "" {
+			return fmt.Errorf("-tls-client-auth verify requires -tls-client-ca")
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return fmt.Errorf("invalid client auth mode '%s'", mode)
+	}
+
+	if t.clientCA == "" {
+		return nil
+	}
+	pemCerts, err := os.ReadFile(t.clientCA)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return fmt.Errorf("no certificates found in client CA %s", t.clientCA)
+	}
+	tlsConfig.ClientCAs = pool
+	return nil
+}
+
+// getCertificateConfig returns the TLS configuration with the server
+// certificates or nil if TLS is disabled.
+func (t *tlsConfig) getCertificateConfig() (*tls.Config, error) {
+	// Self-signed certificate
+	if t.selfSigned {
+		if t.hosts != "" || len(t.certs) > 0 || len(t.keys) > 0 || t.certDir != "" {
+			return nil, fmt.Errorf("-tls-self-signed can not be used with -tls-cert, -tls-key, -tls-cert-dir or -tls-hosts")
+		}
+		cert, err := newSelfSignedCertificate(strings.Split(t.selfSignedHosts, ","))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create self-signed certificate: %w", err)
+		}
+		logCertificate(cert)
+		return &tls.Config{
+			Certificates: []tls.Certificate{*cert},
+		}, nil
+	}
+
 	// ACME (Let's Encrypt)
 	if t.hosts != "" {
+		if t.renewBefore < 0 {
+			return nil, fmt.Errorf("invalid renew before duration '%s': must not be negative", t.renewBefore)
+		}
 		hosts := strings.Split(t.hosts, ",")
-		manager := autocert.Manager{
-			Cache:      autocert.DirCache(t.cacheDir),
-			Prompt:     autocert.AcceptTOS,
-			HostPolicy: autocert.HostWhitelist(hosts...),
+		t.acmeManager = &autocert.Manager{
+			Cache:       autocert.DirCache(t.cacheDir),
+			Prompt:      autocert.AcceptTOS,
+			HostPolicy:  autocert.HostWhitelist(hosts...),
+			RenewBefore: t.renewBefore,
+			Email:       t.acmeEmail,
 		}
-		return manager.TLSConfig(), nil
+		if t.acmeDirectory != "" {
+			t.acmeManager.Client = &acme.Client{
+				DirectoryURL: t.acmeDirectory,
+			}
+		}
+		tlsConfig := t.acmeManager.TLSConfig()
+		tlsConfig.GetCertificate = logFirstUse(tlsConfig.GetCertificate)
+		return tlsConfig, nil
+	}
+
+	if t.httpChallenge {
+		return nil, fmt.Errorf("-tls-http-challenge requires -tls-hosts")
+	}
+	if t.acmeDirectory != "" || t.acmeEmail != "" {
+		return nil, fmt.Errorf("-tls-acme-directory and -tls-acme-email require -tls-hosts")
 	}
 
-	// Local Certificate File
-	if t.cert != "" || t.key != "" {
-		cert, err := tls.LoadX509KeyPair(t.cert, t.key)
+	// Local Certificate Files. reloaded on SIGHUP
+	if len(t.certs) > 0 || len(t.keys) > 0 || t.certDir != "" {
+		certs, err := t.loadCertificates()
 		if err != nil {
 			return nil, err
 		}
+		for _, cert := range certs {
+			cert.watchSignal()
+		}
 		return &tls.Config{
-			Certificates: []tls.Certificate{cert},
+			GetCertificate: certs.getCertificate,
 		}, nil
 	}
 
@@ -141,6 +756,90 @@ func (t *tlsConfig) getConfig() (*tls.Config, error) {
 	return nil, nil
 }
 
+// getRedirectServer returns the server which redirects HTTP to HTTPS if
+// -tls-redirect or -tls-http-challenge is enabled. With ACME the HTTP-01
+// challenge is served as well. tlsAddr is the listen address of the HTTPS
+// server.
+func (t *tlsConfig) getRedirectServer(tlsAddr string) *http.Server {
+	addr := t.redirectAddr
+	if t.httpChallenge {
+		addr = t.httpChallengeAddr
+	} else if !t.redirect {
+		return nil
+	}
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	if port == "443" {
+		port = ""
+	}
+	handler := httpsRedirect(port)
+	if t.acmeManager != nil {
+		handler = t.acmeManager.HTTPHandler(handler)
+	}
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// httpsRedirect redirects to the same host and URI using HTTPS. If port is
+// set it is used as port of the HTTPS URL.
+func httpsRedirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// loadCertificates loads the certificate pairs from -tls-cert/-tls-key and
+// -tls-cert-dir.
+func (t *tlsConfig) loadCertificates() (sniCertificates, error) {
+	if len(t.certs) != len(t.keys) {
+		return nil, fmt.Errorf("-tls-cert and -tls-key have to be specified the same number of times")
+	}
+	certFiles := slices.Clone(t.certs)
+	keyFiles := slices.Clone(t.keys)
+	if t.certDir != "" {
+		entries, err := os.ReadDir(t.certDir)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, entry := range entries {
+			name, ok := strings.CutSuffix(entry.Name(), ".crt")
+			if !ok || entry.IsDir() {
+				continue
+			}
+			keyFile := filepath.Join(t.certDir, name+".key")
+			if _, err := os.Stat(keyFile); err != nil {
+				return nil, fmt.Errorf("no key for certificate %s: %w", entry.Name(), err)
+			}
+			certFiles = append(certFiles, filepath.Join(t.certDir, entry.Name()))
+			keyFiles = append(keyFiles, keyFile)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("no certificates (*.crt) found in %s", t.certDir)
+		}
+	}
+
+	certs := sniCertificates{}
+	for i := range certFiles {
+		reloader, err := newCertReloader(certFiles[i], keyFiles[i])
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, reloader)
+	}
+	return certs, nil
+}
+
 func buildHanlderChain(cfgChain []config.HandlerConfig) (http.Handler, error) {
 	if len(cfgChain) == 0 {
 		return logRequest(newStaticResponseHandler().ServeHTTP), nil
@@ -166,10 +865,58 @@ func buildHanlderChain(cfgChain []config.HandlerConfig) (http.Handler, error) {
 	if err != nil {
 		return nil, fmt.Errorf("invalid configuration in '%s' handler: %w", handlerCfg.Name, err)
 	}
+	// the methods setting is available for all handlers
+	if methods := handlerCfg.Settings.List("methods"); len(methods) > 0 {
+		handler = allowMethods(methods, handler)
+	}
 	return chain(mws...)(handler.ServeHTTP), nil
 }
 
-func getHandler(cfg map[string][]config.HandlerConfig) (http.Handler, error) {
+// allowMethods responds with 405 to requests with methods not in methods.
+func allowMethods(methods []string, next http.Handler) http.Handler {
+	for i := range methods {
+		methods[i] = strings.ToUpper(methods[i])
+	}
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(methods, r.Method) {
+			w.Header().Set("Allow", allow)
+			httpError(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+const (
+	// slashRedirectMux uses the default behavior of http.ServeMux which
+	// redirects /foo to /foo/ if only /foo/ is registered.
+	slashRedirectMux = "mux"
+	// slashRedirectNone serves /foo with the handler of /foo/ instead of
+	// redirecting.
+	slashRedirectNone = "none"
+	// slashRedirectAlways redirects every path without a trailing slash to
+	// the path with a trailing slash.
+	slashRedirectAlways = "always"
+)
+
+func getHandler(cfg map[string][]config.HandlerConfig, slashRedirect string) (http.Handler, error) {
+	switch slashRedirect {
+	case slashRedirectMux, slashRedirectNone:
+	case slashRedirectAlways:
+		handler, err := getHandler(cfg, slashRedirectMux)
+		if err != nil {
+			return nil, err
+		}
+		return withTrailingSlash(handler), nil
+	default:
+		return nil, fmt.Errorf("invalid slash redirect mode '%s'", slashRedirect)
+	}
+
+	if global, ok := cfg[config.GlobalKey]; ok {
+		cfg = withGlobalMiddlewares(cfg, global)
+	}
+
 	if len(cfg) == 0 {
 		return logRequest(infoHandler), nil
 	}
@@ -186,10 +933,124 @@ func getHandler(cfg map[string][]config.HandlerConfig) (http.Handler, error) {
 			return nil, err
 		}
 		mux.Handle(path, handler)
+
+		if slashRedirect != slashRedirectNone {
+			continue
+		}
+		// register /foo explicitly for /foo/ so the mux does not redirect
+		_, pathOnly, _ := strings.Cut(path, "/")
+		withoutSlash := strings.TrimSuffix(path, "/")
+		if pathOnly == "" || withoutSlash == path {
+			continue
+		}
+		if _, ok := cfg[withoutSlash]; ok {
+			continue
+		}
+		mux.Handle(withoutSlash, handler)
 	}
 	return mux, nil
 }
 
+// withGlobalMiddlewares returns a copy of cfg where the global middlewares
+// are prepended to the chain of every route. If there are no routes the global
+// middlewares are applied to the info handler on /.
+func withGlobalMiddlewares(cfg map[string][]config.HandlerConfig, global []config.HandlerConfig) map[string][]config.HandlerConfig {
+	routes := map[string][]config.HandlerConfig{}
+	for path, chain := range cfg {
+		if path == config.GlobalKey {
+			continue
+		}
+		routes[path] = append(slices.Clone(global), chain...)
+	}
+	if len(routes) == 0 {
+		routes["/"] = append(slices.Clone(global), config.HandlerConfig{Name: "info"})
+	}
+	return routes
+}
+
+// withTrailingSlash redirects all paths without a trailing slash to the path
+// with a trailing slash.
+func withTrailingSlash(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		target := *r.URL
+		target.Path += "/"
+		target.RawPath = ""
+		http.Redirect(w, r, target.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// readConfig reads the handler configuration from the arguments or if file is
+// set from file. If file is - the configuration is read from stdin.
+func readConfig(format, file string, args []string, stdin io.Reader) (map[string][]config.HandlerConfig, error) {
+	if file == "" {
+		return config.ParseArgsFormat(format, args)
+	}
+	if len(args) > 0 {
+		return nil, fmt.Errorf("configuration arguments can not be used together with -config")
+	}
+
+	r := stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	// read at most one byte more than allowed to detect too large configs
+	// without reading them completely
+	input, err := io.ReadAll(io.LimitReader(r, int64(config.MaxInputSize)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if len(input) > config.MaxInputSize {
+		return nil, fmt.Errorf("config too large: exceeds the maximum of %d bytes", config.MaxInputSize)
+	}
+	return config.ParseFormat(format, input)
+}
+
+// waitFor dials each address until it becomes reachable or the timeout
+// expires.
+func waitFor(addrs []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, addr := range addrs {
+		for {
+			conn, err := net.DialTimeout("tcp", addr, time.Second)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("dependency %s not reachable after %s: %w", addr, timeout, err)
+			}
+			log.Printf("waiting for %s: %s", addr, err)
+			time.Sleep(time.Second)
+		}
+	}
+	return nil
+}
+
+// withFavicon answers requests to /favicon.ico ahead of the configured routes
+// with the icon file or 204 if no file is set.
+func withFavicon(next http.Handler, file string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/favicon.ico" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if file == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.ServeFile(w, r, file)
+	})
+}
+
 func listOptions() {
 	fmt.Println("handlers:")
 	for handler := range handlers {
@@ -206,6 +1067,19 @@ func listOptions() {
 func run() error {
 	// list handlers and middlewares
 	var list bool
+	configFormat := config.FormatDSL
+	configFile := ""
+	waitForAddrs := ""
+	waitTimeout := time.Minute
+	favicon := false
+	faviconFile := ""
+	capture := 0
+	requestTimeout := time.Duration(0)
+	slashRedirect := slashRedirectMux
+	seed := uint64(0)
+	errFormat := errorFormatText
+	logFmt := logFormatText
+	jwtKeyFile := ""
 	// fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	// fs.Usage = func() {
 	// 	// TODO: extend with description of handlers and middlewares
@@ -216,23 +1090,78 @@ func run() error {
 	serverConfig := newDefaultServer()
 	serverConfig.bindFlags(flag.CommandLine)
 	flag.BoolVar(&list, "list", false, "list available handlers and middlewares")
+	flag.StringVar(&configFormat, "config-format", configFormat, "format of the handler configuration (dsl, json, yaml)")
+	flag.StringVar(&configFile, "config", configFile, "read the handler configuration from a file instead of the arguments. use - to read from stdin")
+	flag.StringVar(&waitForAddrs, "wait-for", waitForAddrs, "comma-separated list of host:port addresses which have to be reachable before the server starts")
+	flag.DurationVar(&waitTimeout, "wait-timeout", waitTimeout, "how long to wait for the addresses of -wait-for")
+	flag.BoolVar(&favicon, "favicon", favicon, "respond to /favicon.ico with 204 No Content")
+	flag.StringVar(&faviconFile, "favicon-file", faviconFile, "serve this file on /favicon.ico (implies -favicon)")
+	flag.IntVar(&capture, "capture", capture, "keep the last N requests in memory and serve them on /captures")
+	flag.DurationVar(&requestTimeout, "request-timeout", requestTimeout, "cancel the context of each request after this duration. this also cancels upstream requests of the proxy handler")
+	flag.BoolVar(&allowDestructive, "allow-destructive", allowDestructive, "allow middlewares which deliberately damage responses (corrupt)")
+	flag.StringVar(&slashRedirect, "slash-redirect", slashRedirect, "trailing slash handling: mux redirects /foo to /foo/ if only /foo/ is configured, none serves /foo/ also on /foo, always redirects every path to the form with a trailing slash")
+	flag.Uint64Var(&seed, "seed", seed, "seed for all randomized behavior to get reproducible results (0 uses a random seed)")
+	flag.StringVar(&errFormat, "error-format", errFormat, "format of the error responses of the handlers and middlewares (text, json)")
+	flag.StringVar(&jwtKeyFile, "jwt-key", jwtKeyFile, "verify the signature of JWTs shown by the info handler with the key in this file (PEM encoded RSA public key or HMAC secret)")
+	flag.DurationVar(&jwtLeeway, "jwt-leeway", jwtLeeway, "tolerated clock skew when the exp and nbf claims of JWTs are checked")
+	flag.StringVar(&logFmt, "log-format", logFmt, "format of the request log (text, json)")
 	flag.Parse()
 
+	err := setErrorFormat(errFormat)
+	if err != nil {
+		return err
+	}
+
+	err = setLogFormat(logFmt)
+	if err != nil {
+		return err
+	}
+
+	if jwtKeyFile != "" {
+		jwtKey, err = loadJWTKey(jwtKeyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	if seed != 0 {
+		rng.seed(seed)
+	}
+
 	if list {
 		listOptions()
 		return nil
 	}
 
-	cfg, err := config.ParseArgs(flag.Args())
+	cfg, err := readConfig(configFormat, configFile, flag.Args(), os.Stdin)
 	if err != nil {
 		return err
 	}
 
-	handler, err := getHandler(cfg)
+	handler, err := getHandler(cfg, slashRedirect)
 	if err != nil {
 		return err
 	}
 
+	if requestTimeout > 0 {
+		handler = withDeadline(requestTimeout)(handler.ServeHTTP)
+	}
+
+	if favicon || faviconFile != "" {
+		handler = withFavicon(handler, faviconFile)
+	}
+
+	if capture > 0 {
+		handler = withCapture(handler, capture)
+	}
+
+	if waitForAddrs != "" {
+		err = waitFor(strings.Split(waitForAddrs, ","), waitTimeout)
+		if err != nil {
+			return err
+		}
+	}
+
 	err = serverConfig.run(handler)
 	if err != nil {
 		return err
@@ -247,3 +1176,15 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// stringList is a flag which can be specified multiple times.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
@@ -2,8 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
 	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/dvob/http-server/config"
+	"golang.org/x/net/http2"
 )
 
 func TestNBytesReader_read0(t *testing.T) {
@@ -28,3 +43,701 @@ func TestNBytesReader_readN(t *testing.T) {
 		t.Fatalf("bytes != 1337")
 	}
 }
+
+func TestWaitFor(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+
+	err = waitFor([]string{addr}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Close()
+	err = waitFor([]string{addr}, 0)
+	if err == nil {
+		t.Fatal("expected error for unreachable address")
+	}
+}
+
+func TestInterfaceAddr(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loopback := ""
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			loopback = iface.Name
+			break
+		}
+	}
+	if loopback == "" {
+		t.Skip("no loopback interface")
+	}
+
+	addr, err := interfaceAddr(loopback, ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !net.ParseIP(host).IsLoopback() || port != "8080" {
+		t.Fatalf("got %s, want loopback address with port 8080", addr)
+	}
+
+	_, err = interfaceAddr("does-not-exist0", ":8080")
+	if err == nil {
+		t.Fatal("expected error for unknown interface")
+	}
+}
+
+func TestNBytesReader_smallBuffer(t *testing.T) {
+	r := newNBytesReader(10)
+	p := make([]byte, 3)
+	total := 0
+	for {
+		n, err := r.Read(p)
+		total += n
+		if !bytes.Equal(p[:n], bytes.Repeat([]byte{'A'}, n)) {
+			t.Fatalf("unexpected content %q", p[:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if total != 10 {
+		t.Fatalf("read %d bytes, want 10", total)
+	}
+}
+
+func TestNBytesReaderFill(t *testing.T) {
+	for _, test := range []struct {
+		fill     string
+		expected string
+	}{
+		{"", "AAAAAAA"},
+		{"zero", "\x00\x00\x00\x00\x00\x00\x00"},
+		{"abc", "abcabca"},
+	} {
+		// small buffer to check that the pattern continues across reads
+		got := &bytes.Buffer{}
+		_, err := io.CopyBuffer(struct{ io.Writer }{got}, newNBytesReaderFill(7, parseFill(test.fill)), make([]byte, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != test.expected {
+			t.Errorf("fill %q: got %q, want %q", test.fill, got.String(), test.expected)
+		}
+	}
+
+	random, err := io.ReadAll(newNBytesReaderFill(1000, parseFill("random")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(random) != 1000 || bytes.Count(random, []byte{0}) > 100 {
+		t.Fatalf("unexpected random data of length %d", len(random))
+	}
+}
+
+func BenchmarkNBytesReader(b *testing.B) {
+	const size = 100 * 1024 * 1024
+	b.SetBytes(size)
+	for i := 0; i < b.N; i++ {
+		_, err := io.Copy(io.Discard, newNBytesReader(size))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWithFavicon(t *testing.T) {
+	handler := withFavicon(newStaticResponseHandler(), "")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/favicon.ico", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/foo", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestShutdownAfter(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	defer shuttingDown.Store(false)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.Repeat("A", 8<<20)
+	conns := &activeConns{}
+	srv := &http.Server{ConnState: conns.connState}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv.Handler = shutdownAfter(2, cancel, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	stopped := make(chan struct{})
+	shutdownDone := shutdownOnDone(ctx, stopped, srv, 5*time.Second, conns)
+
+	// same sequence as in run
+	done := make(chan error)
+	go func() {
+		err := srv.Serve(l)
+		close(stopped)
+		<-shutdownDone
+		done <- err
+	}()
+
+	url := "http://" + l.Addr().String()
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: got %d, want %d", i, resp.StatusCode, http.StatusOK)
+		}
+		// read the last response slowly so that the shutdown starts
+		// before it is complete
+		if i == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+		got, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || len(got) != len(body) {
+			t.Fatalf("request %d: got %d bytes (%v), want %d", i, len(got), err, len(body))
+		}
+	}
+
+	select {
+	case err := <-done:
+		if err != http.ErrServerClosed {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	resp, err := http.Get(url)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("request after limit succeeded with %d", resp.StatusCode)
+	}
+}
+
+func TestShutdownOnDone(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	conns := &activeConns{}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte("done"))
+		}),
+		ConnState: conns.connState,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	shutdownDone := shutdownOnDone(ctx, stopped, srv, 5*time.Second, conns)
+	go srv.Serve(l)
+
+	type result struct {
+		body string
+		err  error
+	}
+	resultCh := make(chan result)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			resultCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		resultCh <- result{string(body), err}
+	}()
+
+	<-started
+	if conns.count.Load() != 1 {
+		t.Fatalf("got %d active connections, want 1", conns.count.Load())
+	}
+	cancel()
+
+	res := <-resultCh
+	if res.err != nil || res.body != "done" {
+		t.Fatalf("in-flight request failed: %q %v", res.body, res.err)
+	}
+	select {
+	case <-shutdownDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not complete")
+	}
+	close(stopped)
+}
+
+func TestServeUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+
+	// stale socket from a previous run
+	stale, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	cfg := serverConfig{unixSocket: path}
+	l, err := cfg.listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: newStaticResponseHandler()}
+	done := make(chan error)
+	go func() {
+		done <- srv.Serve(l)
+	}()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		resp, err = client.Get("http://unix/")
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	srv.Shutdown(context.Background())
+	if err := <-done; err != http.ErrServerClosed {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file was not removed: %v", err)
+	}
+
+	// regular files are not removed
+	err = os.WriteFile(path, nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = listenUnix(path)
+	if err == nil {
+		t.Fatal("expected error if path is not a socket")
+	}
+}
+
+func TestReadConfigStdin(t *testing.T) {
+	stdin := strings.NewReader("/info: log info\n/: log static{body: foo}\n")
+	cfg, err := readConfig(config.FormatDSL, "-", nil, stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]config.HandlerConfig{
+		"/info": {{Name: "log"}, {Name: "info"}},
+		"/":     {{Name: "log"}, {Name: "static", Settings: config.Settings{"body": {"foo"}}}},
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Fatalf("got %#v, want %#v", cfg, expected)
+	}
+
+	cfg, err = readConfig(config.FormatDSL, "-", nil, strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg) != 0 {
+		t.Fatalf("expected empty config, got %#v", cfg)
+	}
+
+	_, err = readConfig(config.FormatDSL, "-", []string{"static"}, strings.NewReader(""))
+	if err == nil {
+		t.Fatal("expected error if arguments and -config are used together")
+	}
+	for _, format := range []string{config.FormatDSL, config.FormatJSON, config.FormatYAML} {
+		_, err = readConfig(format, "-", nil, strings.NewReader(strings.Repeat(" ", config.MaxInputSize+1)))
+		if err == nil || !strings.Contains(err.Error(), "config too large") {
+			t.Fatalf("%s: got error %v, want config too large", format, err)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "config.json")
+	err = os.WriteFile(file, []byte(strings.Repeat(" ", config.MaxInputSize+1)), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readConfig(config.FormatJSON, file, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "config too large") {
+		t.Fatalf("got error %v, want config too large", err)
+	}
+}
+
+func TestGetHandlerVirtualHost(t *testing.T) {
+	cfg, err := config.Parse([]byte(`static{body: default} @api.example.com /users: static{body: users} @www.example.com static{body: www}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := getHandler(cfg, slashRedirectMux)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		url      string
+		expected string
+	}{
+		{"http://api.example.com/users", "users"},
+		{"http://api.example.com:8080/users", "users"},
+		{"http://api.example.com/other", "default"},
+		{"http://www.example.com/foo", "www"},
+		{"http://other.example.com/users", "default"},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.url, nil))
+		if rec.Body.String() != test.expected {
+			t.Errorf("%s: got %q, want %q", test.url, rec.Body.String(), test.expected)
+		}
+	}
+}
+
+func TestGetHandlerMultiplePaths(t *testing.T) {
+	cfg, err := config.Parse([]byte(`/: static{body: default} /a, /b, /c: static{body: shared}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := getHandler(cfg, slashRedirectMux)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path     string
+		expected string
+	}{
+		{"/a", "shared"},
+		{"/b", "shared"},
+		{"/c", "shared"},
+		{"/d", "default"},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Body.String() != test.expected {
+			t.Errorf("%s: got %q, want %q", test.path, rec.Body.String(), test.expected)
+		}
+	}
+}
+
+func TestGetHandlerMethods(t *testing.T) {
+	cfg, err := config.Parse([]byte(`GET /api: static{body: get} POST /api: static{body: post} /: static{body: default}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := getHandler(cfg, slashRedirectMux)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		expected string
+	}{
+		{http.MethodGet, "/api", "get"},
+		{http.MethodPost, "/api", "post"},
+		{http.MethodPut, "/api", "default"},
+		{http.MethodPost, "/other", "default"},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+		if rec.Body.String() != test.expected {
+			t.Errorf("%s %s: got %q, want %q", test.method, test.path, rec.Body.String(), test.expected)
+		}
+	}
+}
+
+func TestGetHandlerSlashRedirect(t *testing.T) {
+	cfg, err := config.Parse([]byte(`/: static{body: root} /foo/: static{body: foo}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		mode     string
+		path     string
+		code     int
+		location string
+	}{
+		{slashRedirectMux, "/foo", http.StatusTemporaryRedirect, "/foo/"},
+		{slashRedirectMux, "/foo/", http.StatusOK, ""},
+		{slashRedirectNone, "/foo", http.StatusOK, ""},
+		{slashRedirectNone, "/foo/", http.StatusOK, ""},
+		{slashRedirectAlways, "/foo", http.StatusMovedPermanently, "/foo/"},
+		{slashRedirectAlways, "/bar?x=1", http.StatusMovedPermanently, "/bar/?x=1"},
+		{slashRedirectAlways, "/foo/", http.StatusOK, ""},
+	} {
+		handler, err := getHandler(cfg, test.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.code || rec.Header().Get("Location") != test.location {
+			t.Errorf("%s %s: got %d %q, want %d %q", test.mode, test.path, rec.Code, rec.Header().Get("Location"), test.code, test.location)
+		}
+		if test.code == http.StatusOK && rec.Body.String() != "foo" {
+			t.Errorf("%s %s: got body %q, want foo", test.mode, test.path, rec.Body.String())
+		}
+	}
+}
+
+func TestGetHandlerGlobalMiddlewares(t *testing.T) {
+	cfg, err := config.Parse([]byte(`@global log /foo: static{body: foo} /bar: header{X-Foo: bar} static{body: bar}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := getHandler(cfg, slashRedirectMux)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logOutput := &bytes.Buffer{}
+	log.SetOutput(logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	for _, path := range []string{"/foo", "/bar"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if !strings.Contains(logOutput.String(), "url="+path+" ") {
+			t.Fatalf("request to %s was not logged: %q", path, logOutput.String())
+		}
+	}
+}
+
+func TestBuildHandlerChainMethods(t *testing.T) {
+	cfg, err := config.Parse([]byte(`echo{methods: "POST,put"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := buildHanlderChain(cfg["/"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "POST, PUT" {
+		t.Fatalf("got Allow %q, want %q", allow, "POST, PUT")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader("foo")))
+	if rec.Code != http.StatusOK || rec.Body.String() != "foo" {
+		t.Fatalf("got %d %q, want 200 foo", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWithPprof(t *testing.T) {
+	handler := withPprof(newStaticResponseHandler())
+	for _, test := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/debug/pprof/", http.StatusOK, "goroutine"},
+		{"/debug/pprof/cmdline", http.StatusOK, ""},
+		{"/", http.StatusOK, "ok"},
+		{"/debug/pprof", http.StatusOK, "ok"},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.code {
+			t.Errorf("%s: got %d, want %d", test.path, rec.Code, test.code)
+		}
+		if !strings.Contains(rec.Body.String(), test.body) {
+			t.Errorf("%s: body %q does not contain %q", test.path, rec.Body.String(), test.body)
+		}
+	}
+}
+
+func TestLimitInFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := limitInFlight(1, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		done <- rec.Code
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("got %d, want %d", code, http.StatusOK)
+	}
+
+	// the slot is free again
+	rec = httptest.NewRecorder()
+	go func() { <-started }()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestShutdownEndpoint(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	defer shuttingDown.Store(false)
+
+	called := false
+	handler := withShutdownEndpoint(newStaticResponseHandler(), func() { called = true })
+
+	for _, test := range []struct {
+		method     string
+		path       string
+		remoteAddr string
+		code       int
+		called     bool
+	}{
+		{http.MethodPost, "/", "127.0.0.1:1234", http.StatusOK, false},
+		{http.MethodPost, "/shutdown", "192.0.2.1:1234", http.StatusForbidden, false},
+		{http.MethodGet, "/shutdown", "127.0.0.1:1234", http.StatusMethodNotAllowed, false},
+		{http.MethodPost, "/shutdown", "[::1]:1234", http.StatusAccepted, true},
+	} {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.RemoteAddr = test.remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Errorf("%s %s from %s: got %d, want %d", test.method, test.path, test.remoteAddr, rec.Code, test.code)
+		}
+		if called != test.called {
+			t.Errorf("%s %s from %s: got called %t, want %t", test.method, test.path, test.remoteAddr, called, test.called)
+		}
+	}
+	if !shuttingDown.Load() {
+		t.Fatal("shutting down state was not set")
+	}
+}
+
+func TestShutdownEndpointUnix(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	defer shuttingDown.Store(false)
+
+	path := filepath.Join(t.TempDir(), "http.sock")
+	cfg := serverConfig{unixSocket: path}
+	l, err := cfg.listen("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := make(chan struct{})
+	srv := &http.Server{Handler: withShutdownEndpoint(newStaticResponseHandler(), func() { close(called) })}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	resp, err := client.Post("http://unix/shutdown", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("got %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown was not called")
+	}
+}
+
+func TestH2C(t *testing.T) {
+	srv := httptest.NewServer(withH2C(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%d", r.ProtoMajor)
+	})))
+	defer srv.Close()
+
+	// HTTP/2 with prior knowledge without TLS
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+	}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ProtoMajor != 2 || string(body) != "2" {
+		t.Fatalf("got response proto %s and request proto major %s, want HTTP/2", resp.Proto, body)
+	}
+}
+
+func TestH2CWithTLS(t *testing.T) {
+	cfg := newDefaultServer()
+	cfg.h2c = true
+	cfg.tlsConfig.selfSigned = true
+	_, err := cfg.getServer()
+	if err == nil || !strings.Contains(err.Error(), "-h2c can not be used with TLS") {
+		t.Fatalf("got error %v, want error for -h2c with TLS", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// metrics counts the requests which pass the log middleware and serves the
+// counters in the Prometheus text format. It is nil if -metrics is not set.
+var metrics *requestMetrics
+
+type requestMetrics struct {
+	total    atomic.Int64
+	inFlight atomic.Int64
+
+	mu    sync.Mutex
+	codes map[int]int64
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{
+		codes: map[int]int64{},
+	}
+}
+
+// start is called before a request is handled.
+func (m *requestMetrics) start() {
+	if m == nil {
+		return
+	}
+	m.inFlight.Add(1)
+}
+
+// done is called after a request was handled with the status code.
+func (m *requestMetrics) done(code int) {
+	if m == nil {
+		return
+	}
+	m.inFlight.Add(-1)
+	m.total.Add(1)
+	m.mu.Lock()
+	m.codes[code]++
+	m.mu.Unlock()
+}
+
+func (m *requestMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	codes := maps.Clone(m.codes)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintln(w, "# HELP http_server_requests_total Total number of handled requests.")
+	fmt.Fprintln(w, "# TYPE http_server_requests_total counter")
+	fmt.Fprintf(w, "http_server_requests_total %d\n", m.total.Load())
+	fmt.Fprintln(w, "# HELP http_server_requests_in_flight Number of requests which are currently handled.")
+	fmt.Fprintln(w, "# TYPE http_server_requests_in_flight gauge")
+	fmt.Fprintf(w, "http_server_requests_in_flight %d\n", m.inFlight.Load())
+	fmt.Fprintln(w, "# HELP http_server_responses_total Number of responses by status code.")
+	fmt.Fprintln(w, "# TYPE http_server_responses_total counter")
+	for _, code := range slices.Sorted(maps.Keys(codes)) {
+		fmt.Fprintf(w, "http_server_responses_total{code=\"%d\"} %d\n", code, codes[code])
+	}
+}
+
+// handler serves the metrics on /metrics and all other requests with next.
+func (m *requestMetrics) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics" {
+			m.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
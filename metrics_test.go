@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	metrics = newRequestMetrics()
+	defer func() { metrics = nil }()
+
+	inFlight := int64(0)
+	handler := metrics.handler(logRequest(func(w http.ResponseWriter, r *http.Request) {
+		inFlight = metrics.inFlight.Load()
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	for _, path := range []string{"/", "/", "/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if inFlight != 1 {
+		t.Fatalf("got %d requests in flight within the handler, want 1", inFlight)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{
+		"http_server_requests_total 3\n",
+		"http_server_requests_in_flight 0\n",
+		"http_server_responses_total{code=\"200\"} 2\n",
+		"http_server_responses_total{code=\"404\"} 1\n",
+		"# TYPE http_server_requests_total counter\n",
+	} {
+		if !strings.Contains(rec.Body.String(), line) {
+			t.Errorf("metrics do not contain %q:\n%s", line, rec.Body.String())
+		}
+	}
+}
@@ -2,21 +2,33 @@ package main
 
 import (
 	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/dvob/http-server/config"
 	"github.com/felixge/httpsnoop"
 )
 
-type middlewareFactory func(config map[string]string) (middleware, error)
+type middlewareFactory func(config config.Settings) (middleware, error)
 
-func noConfig[T any](t T) func(map[string]string) (T, error) {
-	return func(_ map[string]string) (T, error) {
+func noConfig[T any](t T) func(config.Settings) (T, error) {
+	return func(_ config.Settings) (T, error) {
 		return t, nil
 	}
 }
@@ -24,20 +36,47 @@ func noConfig[T any](t T) func(map[string]string) (T, error) {
 var middlewares = map[string]middlewareFactory{
 	"timeout": noConfig[middleware](timeout),
 	"req":     noConfig[middleware](dumpRequest),
+	"resp":    dumpResponse,
 	"log":     noConfig[middleware](logRequest),
-	"json":    noConfig[middleware](jsonLogger),
-	"header": func(config map[string]string) (middleware, error) {
+	"json":    jsonLogger,
+	"header": func(config config.Settings) (middleware, error) {
 		return func(next http.HandlerFunc) http.HandlerFunc {
 			return func(w http.ResponseWriter, r *http.Request) {
-				for key, value := range config {
-					r.Header.Add(key, value)
+				for key, values := range config {
+					for _, value := range values {
+						r.Header.Add(key, value)
+					}
 				}
 				next.ServeHTTP(w, r)
 			}
 		}, nil
 	},
+	"min-http-version": minHTTPVersion,
+	"deadline":         deadline,
+	"corrupt":          corrupt,
+	"nonce":            nonce,
+	"timing":           timing,
+	"cors":             cors,
+	"require-headers":  requireHeaders,
+	"connection-close": noConfig[middleware](connectionClose),
+	"idempotency":      idempotency,
+	"bearer-auth":      bearerAuth,
+	"auth-token":       bearerAuth,
+	"delay":            delay,
+	"max-uri-length":   maxURILength,
+	"gzip":             gzipMiddleware,
+	"header-out":       headerOut,
+	"rate-limit":       rateLimit,
+	"recover":          noConfig[middleware](recoverPanic),
+	"request-id":       requestID,
+	"fault":            fault,
+	"respond":          respond,
 }
 
+// allowDestructive enables middlewares which deliberately damage responses
+// (e.g. corrupt).
+var allowDestructive bool
+
 type middleware func(http.HandlerFunc) http.HandlerFunc
 
 func chain(middlewares ...middleware) middleware {
@@ -56,7 +95,7 @@ func timeout(next http.HandlerFunc) http.HandlerFunc {
 			rawDuration := params.Get("duration")
 			duration, err := time.ParseDuration(rawDuration)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				httpError(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 			time.Sleep(duration)
@@ -65,11 +104,68 @@ func timeout(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logFormat is the format of the request log (text or json).
+var logFormat = logFormatText
+
+// jsonLog writes the JSON request log without the prefix of the standard
+// logger so that each line is valid JSON.
+var jsonLog = log.New(os.Stderr, "", 0)
+
+func setLogFormat(format string) error {
+	if format != logFormatText && format != logFormatJSON {
+		return fmt.Errorf("invalid log format '%s'", format)
+	}
+	logFormat = format
+	return nil
+}
+
 func logRequest(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		metrics.start()
+		// request-id may run after log and reports the ID back through the
+		// context
+		loggedID := new(string)
+		r = r.WithContext(context.WithValue(r.Context(), loggedRequestIDKey{}, loggedID))
 		m := httpsnoop.CaptureMetrics(next, w, r)
+		metrics.done(m.Code)
+		id := requestIDFromContext(r.Context())
+		if id == "" {
+			id = *loggedID
+		}
+		if logFormat == logFormatJSON {
+			out, _ := json.Marshal(struct {
+				Src       string  `json:"src"`
+				Method    string  `json:"method"`
+				Proto     string  `json:"proto"`
+				URL       string  `json:"url"`
+				Code      int     `json:"code"`
+				DT        float64 `json:"dt"`
+				Written   int64   `json:"written"`
+				RequestID string  `json:"request_id,omitempty"`
+			}{
+				Src:       r.RemoteAddr,
+				Method:    r.Method,
+				Proto:     r.Proto,
+				URL:       r.URL.String(),
+				Code:      m.Code,
+				DT:        float64(m.Duration) / float64(time.Millisecond),
+				Written:   m.Written,
+				RequestID: id,
+			})
+			jsonLog.Print(string(out))
+			return
+		}
+		requestIDField := ""
+		if id != "" {
+			requestIDField = " request_id=" + id
+		}
 		log.Printf(
-			"src=%s method=%s proto=%s url=%s code=%d dt=%s written=%d",
+			"src=%s method=%s proto=%s url=%s code=%d dt=%s written=%d%s",
 			r.RemoteAddr,
 			r.Method,
 			r.Proto,
@@ -77,6 +173,7 @@ func logRequest(next http.HandlerFunc) http.HandlerFunc {
 			m.Code,
 			m.Duration,
 			m.Written,
+			requestIDField,
 		)
 	}
 }
@@ -89,29 +186,847 @@ func dumpRequest(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func jsonLogger(next http.HandlerFunc) http.HandlerFunc {
+// dumpResponse logs the status line and the headers of the response. With the
+// setting body the body is logged as well up to the size max (default 64KB).
+// The response is buffered and sent after the handler returned. For streaming
+// handlers the setting no-buffer passes the response through immediately.
+func dumpResponse(config config.Settings) (middleware, error) {
+	withBody, err := config.Bool("body", false)
+	if err != nil {
+		return nil, err
+	}
+	maxSize, err := config.Int("max", 64<<10)
+	if err != nil {
+		return nil, err
+	}
+	noBuffer, err := config.Bool("no-buffer", false)
+	if err != nil {
+		return nil, err
+	}
+	if !withBody {
+		maxSize = 0
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !noBuffer {
+				buf := newBufferedResponse()
+				next(buf, r)
+				body := buf.body.Bytes()
+				logResponse(r, buf.code, buf.header, body[:min(len(body), maxSize)])
+				buf.writeTo(w)
+				return
+			}
+
+			code := 0
+			body := &bytes.Buffer{}
+			capture := writerFunc(func(b []byte) (int, error) {
+				body.Write(b[:min(len(b), maxSize-body.Len())])
+				return len(b), nil
+			})
+			w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+				WriteHeader: func(writeHeader httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+					return func(c int) {
+						if code == 0 {
+							code = c
+						}
+						writeHeader(c)
+					}
+				},
+				Write: func(write httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+					return func(b []byte) (int, error) {
+						if code == 0 {
+							code = http.StatusOK
+						}
+						capture(b)
+						return write(b)
+					}
+				},
+				ReadFrom: func(readFrom httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+					return func(src io.Reader) (int64, error) {
+						if code == 0 {
+							code = http.StatusOK
+						}
+						if body.Len() < maxSize {
+							src = io.TeeReader(src, capture)
+						}
+						return readFrom(src)
+					}
+				},
+			})
+			next(w, r)
+			logResponse(r, code, w.Header(), body.Bytes())
+		}
+	}, nil
+}
+
+// writerFunc implements io.Writer with a function.
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
+
+func logResponse(r *http.Request, code int, header http.Header, body []byte) {
+	if code == 0 {
+		code = http.StatusOK
+	}
+	out := &bytes.Buffer{}
+	fmt.Fprintf(out, "%s %d %s\r\n", r.Proto, code, http.StatusText(code))
+	header.Write(out)
+	out.WriteString("\r\n")
+	out.Write(body)
+	log.Print(out.String())
+}
+
+// jsonLogger pretty-prints JSON request bodies up to the size max (default
+// 1MB). Bodies without Content-Length (chunked) are read up to max as well.
+// Larger bodies are not printed. The body is passed unchanged to the next
+// handler.
+func jsonLogger(config config.Settings) (middleware, error) {
+	maxSize, err := config.Int("max", 1_000_000)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid max '%d': must be greater than zero", maxSize)
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength == 0 || r.ContentLength > int64(maxSize) {
+				next(w, r)
+				return
+			}
+
+			buf := &bytes.Buffer{}
+			_, err := buf.ReadFrom(io.LimitReader(r.Body, int64(maxSize)+1))
+			// restore the body including the part which was not read
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(buf.Bytes()), r.Body), r.Body}
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			if buf.Len() > maxSize {
+				next(w, r)
+				return
+			}
+
+			dst := &bytes.Buffer{}
+			err = json.Indent(dst, buf.Bytes(), "", "  ")
+			if err != nil {
+				log.Print("could not print json:", err)
+			} else {
+				fmt.Println(dst.String())
+			}
+			next(w, r)
+		}
+	}, nil
+}
+
+// minHTTPVersion rejects requests with a protocol version lower than the
+// configured version (default 1.1). It logs the protocol version and whether a
+// Host header was present.
+func minHTTPVersion(config config.Settings) (middleware, error) {
+	version := "1.1"
+	if v, ok := config.Lookup("version"); ok {
+		version = strings.TrimPrefix(v, "HTTP/")
+	}
+	major, minor, ok := http.ParseHTTPVersion("HTTP/" + version)
+	if !ok {
+		return nil, fmt.Errorf("invalid http version '%s'", version)
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			log.Printf("src=%s proto=%s host_present=%t", r.RemoteAddr, r.Proto, r.Host != "")
+			if !r.ProtoAtLeast(major, minor) {
+				httpError(w, fmt.Sprintf("protocol %s not supported. minimum version is HTTP/%d.%d", r.Proto, major, minor), http.StatusBadRequest)
+				return
+			}
+			next(w, r)
+		}
+	}, nil
+}
+
+// deadline cancels the request context after the configured duration. Since
+// the proxy handler uses the request context for the upstream request, this
+// also cancels in-flight upstream requests.
+func deadline(config config.Settings) (middleware, error) {
+	duration, err := config.Duration("duration", 0)
+	if err != nil {
+		return nil, err
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("missing or invalid configuration 'duration'")
+	}
+	return withDeadline(duration), nil
+}
+
+func withDeadline(duration time.Duration) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), duration)
+			defer cancel()
+			next(w, r.WithContext(ctx))
+		}
+	}
+}
+
+// corrupt flips the configured fraction (rate) of the response body bytes.
+// The length of the body is preserved.
+func corrupt(config config.Settings) (middleware, error) {
+	if !allowDestructive {
+		return nil, fmt.Errorf("corrupt requires -allow-destructive")
+	}
+	rateStr, ok := config.Lookup("rate")
+	if !ok {
+		return nil, fmt.Errorf("missing configuration 'rate'")
+	}
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("invalid rate '%s': must be a number between 0 and 1", rateStr)
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			write := w.Write
+			corruptingWrite := func(b []byte) (int, error) {
+				corrupted := make([]byte, len(b))
+				copy(corrupted, b)
+				for i := range corrupted {
+					if rng.Float64() < rate {
+						corrupted[i] ^= 0xff
+					}
+				}
+				return write(corrupted)
+			}
+			w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+				Write: func(httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+					return corruptingWrite
+				},
+				// io.Copy (e.g. data or fs) would bypass Write
+				ReadFrom: func(httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+					return func(src io.Reader) (int64, error) {
+						return io.Copy(writerFunc(corruptingWrite), src)
+					}
+				},
+			})
+			next(w, r)
+		}
+	}, nil
+}
+
+type nonceKey struct{}
+
+func nonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceKey{}).(string)
+	return nonce
+}
+
+// nonce generates a random nonce for each request and sets it in the response
+// header (default X-Nonce). The nonce is also available in templates (e.g. for
+// CSP: script-src 'nonce-{{ .Nonce }}').
+func nonce(config config.Settings) (middleware, error) {
+	header := "X-Nonce"
+	if h, ok := config.Lookup("header"); ok {
+		header = h
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			b := make([]byte, 16)
+			_, err := cryptorand.Read(b)
+			if err != nil {
+				httpError(w, "failed to generate nonce", http.StatusInternalServerError)
+				return
+			}
+			nonce := base64.StdEncoding.EncodeToString(b)
+			w.Header().Set(header, nonce)
+			next(w, r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce)))
+		}
+	}, nil
+}
+
+// timing reports the time the handler took until the response header was
+// written. By default the duration is written in the Server-Timing format
+// (app;dur=<milliseconds>). With format: plain the duration is written as Go
+// duration (e.g. 1.5ms) to the header X-Response-Time.
+func timing(config config.Settings) (middleware, error) {
+	format := config.Get("format")
+	header := ""
+	switch format {
+	case "", "server-timing":
+		header = "Server-Timing"
+	case "plain":
+		header = "X-Response-Time"
+	default:
+		return nil, fmt.Errorf("invalid format '%s': must be server-timing or plain", format)
+	}
+	if h, ok := config.Lookup("header"); ok {
+		header = h
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			written := false
+			setHeader := func() {
+				if written {
+					return
+				}
+				written = true
+				d := time.Since(start)
+				if format == "plain" {
+					w.Header().Set(header, d.String())
+				} else {
+					w.Header().Add(header, fmt.Sprintf("app;dur=%.3f", float64(d.Microseconds())/1000))
+				}
+			}
+			w = httpsnoop.Wrap(w, httpsnoop.Hooks{
+				WriteHeader: func(writeHeader httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+					return func(code int) {
+						setHeader()
+						writeHeader(code)
+					}
+				},
+				Write: func(write httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+					return func(b []byte) (int, error) {
+						setHeader()
+						return write(b)
+					}
+				},
+				ReadFrom: func(readFrom httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+					return func(src io.Reader) (int64, error) {
+						setHeader()
+						return readFrom(src)
+					}
+				},
+				Flush: func(flush httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+					return func() {
+						setHeader()
+						flush()
+					}
+				},
+			})
+			next(w, r)
+			setHeader()
+		}
+	}, nil
+}
+
+// cors sets the Access-Control-Allow-Origin header for requests from the
+// configured origins. With credentials: true the matched origin is echoed
+// and Access-Control-Allow-Credentials is set. Since browsers reject * for
+// credentialed requests, * is never sent together with credentials.
+//
+// Preflight requests (OPTIONS with Access-Control-Request-Method) are
+// answered with 204. The allowed methods and headers are taken from the
+// settings methods and headers. If they are not set, the requested method and
+// headers are allowed.
+func cors(config config.Settings) (middleware, error) {
+	origins := config.List("origins")
+	if len(origins) == 0 {
+		return nil, fmt.Errorf("missing configuration 'origins'")
+	}
+	credentials, err := config.Bool("credentials", false)
+	if err != nil {
+		return nil, err
+	}
+	maxAge, err := config.Duration("max-age", 0)
+	if err != nil {
+		return nil, err
+	}
+	methods := strings.Join(config.List("methods"), ", ")
+	headers := strings.Join(config.List("headers"), ", ")
+	anyOrigin := slices.Contains(origins, "*")
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+			allowed := true
+			switch {
+			case origin == "":
+			case anyOrigin && !credentials:
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			case anyOrigin || slices.Contains(origins, origin):
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if credentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			default:
+				allowed = false
+			}
+
+			requestMethod := r.Header.Get("Access-Control-Request-Method")
+			if r.Method != http.MethodOptions || origin == "" || requestMethod == "" {
+				next(w, r)
+				return
+			}
+
+			// preflight
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if allowed {
+				if methods != "" {
+					w.Header().Set("Access-Control-Allow-Methods", methods)
+				} else {
+					w.Header().Set("Access-Control-Allow-Methods", requestMethod)
+				}
+				if headers != "" {
+					w.Header().Set("Access-Control-Allow-Headers", headers)
+				} else if requestHeaders := r.Header.Get("Access-Control-Request-Headers"); requestHeaders != "" {
+					w.Header().Set("Access-Control-Allow-Headers", requestHeaders)
+				}
+				if maxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}, nil
+}
+
+type requiredHeader struct {
+	name  string
+	value *regexp.Regexp
+}
+
+// requireHeaders responds with 400 if one of the configured headers is
+// missing. The headers are configured as list of names with an optional
+// regular expression the value has to match (e.g. X-Api-Version=v[12]).
+func requireHeaders(config config.Settings) (middleware, error) {
+	required := []requiredHeader{}
+	for _, header := range config.List("headers") {
+		name, pattern, hasPattern := strings.Cut(header, "=")
+		h := requiredHeader{
+			name: http.CanonicalHeaderKey(strings.TrimSpace(name)),
+		}
+		if hasPattern {
+			var err error
+			h.value, err = regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern for header %s: %w", h.name, err)
+			}
+		}
+		required = append(required, h)
+	}
+	if len(required) == 0 {
+		return nil, fmt.Errorf("missing configuration 'headers'")
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			missing := []string{}
+			invalid := []string{}
+			for _, h := range required {
+				values := r.Header.Values(h.name)
+				if len(values) == 0 {
+					missing = append(missing, h.name)
+					continue
+				}
+				if h.value != nil && !slices.ContainsFunc(values, h.value.MatchString) {
+					invalid = append(invalid, h.name)
+				}
+			}
+			if len(missing) == 0 && len(invalid) == 0 {
+				next(w, r)
+				return
+			}
+			msg := ""
+			if len(missing) > 0 {
+				msg += "missing required headers: " + strings.Join(missing, ", ") + "\n"
+			}
+			if len(invalid) > 0 {
+				msg += "invalid values for headers: " + strings.Join(invalid, ", ") + "\n"
+			}
+			httpError(w, strings.TrimSuffix(msg, "\n"), http.StatusBadRequest)
+		}
+	}, nil
+}
+
+// connectionClose sets Connection: close on the response which makes the
+// server close the connection after the response, so clients can't reuse it.
+// This has no effect for HTTP/2.
+func connectionClose(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		const maxSize = 1_000_000 // 1MB
-		// -1 means chunked
-		if r.ContentLength <= 0 || r.ContentLength > maxSize {
+		w.Header().Set("Connection", "close")
+		next(w, r)
+	}
+}
+
+// bearerAuth requires an Authorization header with one of the configured
+// bearer tokens. Otherwise it responds with 401 and a WWW-Authenticate header
+// according to RFC 6750.
+func bearerAuth(config config.Settings) (middleware, error) {
+	tokens := append(config.List("token"), config.List("tokens")...)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("missing configuration 'token'")
+	}
+	realm := "http-server"
+	if r, ok := config.Lookup("realm"); ok {
+		realm = r
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if !strings.EqualFold(scheme, "Bearer") || token == "" {
+				// no error code if the request lacks authentication information
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", realm))
+				httpError(w, "missing bearer token", http.StatusUnauthorized)
+				return
+			}
+
+			valid := 0
+			for _, t := range tokens {
+				valid |= subtle.ConstantTimeCompare([]byte(token), []byte(t))
+			}
+			if valid != 1 {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q, error=\"invalid_token\", error_description=\"the access token is invalid\"", realm))
+				httpError(w, "invalid bearer token", http.StatusUnauthorized)
+				return
+			}
 			next(w, r)
-			return
 		}
+	}, nil
+}
 
-		buf := &bytes.Buffer{}
-		_, err := buf.ReadFrom(io.LimitReader(r.Body, maxSize))
+// delay delays each request regardless of its parameters, e.g.
+// delay{duration: 200ms, jitter: 50ms}. The setting duration is an alias for
+// mean. The setting distribution selects how the delays are distributed:
+//   - uniform (default): between mean-jitter and mean+jitter
+//   - normal: normal distribution with mean and stddev
+//   - exponential: exponential distribution with mean
+//
+// Negative delays are treated as zero.
+func delay(config config.Settings) (middleware, error) {
+	sample, err := newDelaySampler(config)
+	if err != nil {
+		return nil, err
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(sample()):
+			case <-r.Context().Done():
+				return
+			}
+			next(w, r)
+		}
+	}, nil
+}
+
+func newDelaySampler(config config.Settings) (func() time.Duration, error) {
+	meanKey := "mean"
+	if _, ok := config.Lookup("duration"); ok {
+		if _, ok := config.Lookup("mean"); ok {
+			return nil, fmt.Errorf("'mean' and 'duration' can not be used together")
+		}
+		meanKey = "duration"
+	}
+	mean, err := config.Duration(meanKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	clamp := func(d float64) time.Duration {
+		return time.Duration(max(d, 0))
+	}
+
+	switch distribution := config.Get("distribution"); distribution {
+	case "", "uniform":
+		jitter, err := config.Duration("jitter", 0)
 		if err != nil {
-			log.Print(err)
-			return
+			return nil, err
 		}
-		dst := &bytes.Buffer{}
-		err = json.Indent(dst, buf.Bytes(), "", "  ")
+		return func() time.Duration {
+			return clamp(float64(mean) + (rng.Float64()*2-1)*float64(jitter))
+		}, nil
+	case "normal":
+		stddev, err := config.Duration("stddev", 0)
 		if err != nil {
-			log.Print("could not print json:", err)
-		} else {
-			fmt.Println(dst.String())
+			return nil, err
+		}
+		return func() time.Duration {
+			return clamp(float64(mean) + rng.NormFloat64()*float64(stddev))
+		}, nil
+	case "exponential":
+		return func() time.Duration {
+			return clamp(rng.ExpFloat64() * float64(mean))
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid distribution '%s'", distribution)
+	}
+}
+
+// maxURILength responds with 414 to requests whose request URI is longer than
+// the setting max.
+func maxURILength(config config.Settings) (middleware, error) {
+	maxLength, err := config.Int("max", 0)
+	if err != nil {
+		return nil, err
+	}
+	if maxLength <= 0 {
+		return nil, fmt.Errorf("missing configuration 'max'")
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if len(r.RequestURI) > maxLength {
+				httpError(w, fmt.Sprintf("request URI longer than %d bytes", maxLength), http.StatusRequestURITooLong)
+				return
+			}
+			next(w, r)
+		}
+	}, nil
+}
+
+// headerOut modifies the response headers. Keys which are repeated (e.g.
+// header-out{Set-Cookie: a=1, Set-Cookie: b=2}) add multiple values. The
+// following prefixes change how a key is applied:
+//   - =Key: replaces the values set by the handler
+//   - -Key: removes the header, including default headers like Content-Type
+//     and Date which are added by net/http (the value is ignored)
+//
+// Added headers are set before the next handler is called. Replaced and
+// removed headers are applied right before the headers are sent.
+func headerOut(config config.Settings) (middleware, error) {
+	add := http.Header{}
+	set := http.Header{}
+	remove := []string{}
+	for key, values := range config {
+		switch {
+		case strings.HasPrefix(key, "="):
+			set[http.CanonicalHeaderKey(key[1:])] = values
+		case strings.HasPrefix(key, "-"):
+			remove = append(remove, http.CanonicalHeaderKey(key[1:]))
+		default:
+			add[http.CanonicalHeaderKey(key)] = values
 		}
-		r.Body = io.NopCloser(buf)
-		next(w, r)
 	}
+	if _, ok := set[""]; ok || slices.Contains(remove, "") {
+		return nil, fmt.Errorf("missing header name after '=' or '-'")
+	}
+	for key := range set {
+		if slices.Contains(remove, key) {
+			return nil, fmt.Errorf("header '%s' can not be set and removed", key)
+		}
+	}
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			for key, values := range add {
+				for _, value := range values {
+					w.Header().Add(key, value)
+				}
+			}
+			if len(set) == 0 && len(remove) == 0 {
+				next(w, r)
+				return
+			}
+
+			applied := false
+			apply := func() {
+				if applied {
+					return
+				}
+				applied = true
+				for key, values := range set {
+					w.Header()[key] = slices.Clone(values)
+				}
+				// a nil value suppresses the default headers of net/http
+				for _, key := range remove {
+					w.Header()[key] = nil
+				}
+			}
+			ww := httpsnoop.Wrap(w, httpsnoop.Hooks{
+				WriteHeader: func(writeHeader httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+					return func(code int) {
+						apply()
+						writeHeader(code)
+					}
+				},
+				Write: func(write httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+					return func(b []byte) (int, error) {
+						apply()
+						return write(b)
+					}
+				},
+				ReadFrom: func(readFrom httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+					return func(src io.Reader) (int64, error) {
+						apply()
+						return readFrom(src)
+					}
+				},
+				Flush: func(flush httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+					return func() {
+						apply()
+						flush()
+					}
+				},
+			})
+			next(ww, r)
+			// the handler did not write anything
+			apply()
+		}
+	}, nil
+}
+
+// recoverPanic recovers panics of the next handler, logs the panic with the
+// stack trace and responds with 500 if the headers have not been sent yet.
+// http.ErrAbortHandler is passed on since it is used to abort a response
+// deliberately.
+func recoverPanic(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		headerSent := false
+		ww := httpsnoop.Wrap(w, httpsnoop.Hooks{
+			WriteHeader: func(writeHeader httpsnoop.WriteHeaderFunc) httpsnoop.WriteHeaderFunc {
+				return func(code int) {
+					headerSent = true
+					writeHeader(code)
+				}
+			},
+			Write: func(write httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+				return func(b []byte) (int, error) {
+					headerSent = true
+					return write(b)
+				}
+			},
+			ReadFrom: func(readFrom httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+				return func(src io.Reader) (int64, error) {
+					headerSent = true
+					return readFrom(src)
+				}
+			},
+			Flush: func(flush httpsnoop.FlushFunc) httpsnoop.FlushFunc {
+				return func() {
+					headerSent = true
+					flush()
+				}
+			},
+		})
+
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			log.Printf("panic: method=%s url=%s: %v\n%s", r.Method, r.URL, v, debug.Stack())
+			if !headerSent {
+				httpError(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+		}()
+		next(ww, r)
+	}
+}
+
+type requestIDKey struct{}
+
+// loggedRequestIDKey is the context key of the request ID which is logged by
+// log. It is set by log, so that request-id can report the ID back if it runs
+// after log.
+type loggedRequestIDKey struct{}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID reads the request ID from the request header (default
+// X-Request-Id) or generates a new one. The ID is set in the response header
+// and the request header (e.g. to pass it on to the upstream of a proxy) and
+// is stored in the request context. The ID is logged by log regardless of
+// the order of log and request-id in the chain.
+func requestID(config config.Settings) (middleware, error) {
+	header := "X-Request-Id"
+	if h, ok := config.Lookup("header"); ok {
+		header = h
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if id == "" {
+				b := make([]byte, 16)
+				_, err := cryptorand.Read(b)
+				if err != nil {
+					httpError(w, "failed to generate request id", http.StatusInternalServerError)
+					return
+				}
+				id = hex.EncodeToString(b)
+				r.Header.Set(header, id)
+			}
+			w.Header().Set(header, id)
+			if loggedID, ok := r.Context().Value(loggedRequestIDKey{}).(*string); ok {
+				*loggedID = id
+			}
+			next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+		}
+	}, nil
+}
+
+// fault answers requests with the probability rate (0 to 1) with the status
+// code (default 500) instead of calling the next handler. The response body
+// can be set with the setting body. With the setting seed the faults are
+// reproducible independently of other randomized behavior.
+func fault(config config.Settings) (middleware, error) {
+	rateStr, ok := config.Lookup("rate")
+	if !ok {
+		return nil, fmt.Errorf("missing configuration 'rate'")
+	}
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("invalid rate '%s': must be a number between 0 and 1", rateStr)
+	}
+	code, err := config.Int("code", http.StatusInternalServerError)
+	if err != nil {
+		return nil, err
+	}
+	if code < 100 || code > 599 {
+		return nil, fmt.Errorf("invalid status code '%d'", code)
+	}
+	body, hasBody := config.Lookup("body")
+	random := rng
+	if seedStr, ok := config.Lookup("seed"); ok {
+		seed, err := strconv.ParseUint(seedStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seed '%s'", seedStr)
+		}
+		random = newLockedRand(seed)
+	}
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if random.Float64() >= rate {
+				next(w, r)
+				return
+			}
+			if !hasBody {
+				httpError(w, http.StatusText(code), code)
+				return
+			}
+			w.WriteHeader(code)
+			io.WriteString(w, body)
+		}
+	}, nil
+}
+
+// respond answers all requests with the status code and the optional body
+// instead of calling the next handler. Unlike the static handler it can be
+// used in the middle of a chain (e.g. to temporarily disable a path).
+func respond(config config.Settings) (middleware, error) {
+	codeStr, ok := config.Lookup("code")
+	if !ok {
+		return nil, fmt.Errorf("missing configuration 'code'")
+	}
+	code, err := strconv.Atoi(codeStr)
+	if err != nil || code < 100 || code > 599 {
+		return nil, fmt.Errorf("invalid status code '%s'", codeStr)
+	}
+	body := config.Get("body")
+	return func(_ http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+			io.WriteString(w, body)
+		}
+	}, nil
 }
@@ -0,0 +1,880 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dvob/http-server/config"
+)
+
+func TestHeaderMultiValue(t *testing.T) {
+	cfg, err := config.Parse([]byte("header{X-Test: a, X-Test: b} static"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw, err := middlewares["header"](cfg["/"][0].Settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	h := mw(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Values("X-Test")
+	})
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	expected := []string{"a", "b"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %v, want %v", got, expected)
+	}
+}
+
+func TestMinHTTPVersion(t *testing.T) {
+	mw, err := minHTTPVersion(config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(mw(newStaticResponseHandler().ServeHTTP))
+	defer srv.Close()
+
+	for _, test := range []struct {
+		request  string
+		expected string
+	}{
+		{"GET / HTTP/1.0\r\n\r\n", "HTTP/1.0 400 Bad Request"},
+		{"GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n", "HTTP/1.1 200 OK"},
+	} {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.Write([]byte(test.request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(status) != test.expected {
+			t.Fatalf("got %q, want %q", status, test.expected)
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	allowDestructive = false
+	_, err := corrupt(config.Settings{"rate": {"0.1"}})
+	if err == nil {
+		t.Fatal("expected error without -allow-destructive")
+	}
+
+	allowDestructive = true
+	defer func() { allowDestructive = false }()
+	mw, err := corrupt(config.Settings{"rate": {"0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const size = 10000
+	check := func(name string, body []byte) {
+		t.Helper()
+		if len(body) != size {
+			t.Fatalf("%s: got %d bytes, want %d", name, len(body), size)
+		}
+		changed := 0
+		for _, b := range body {
+			if b != 'A' {
+				changed++
+			}
+		}
+		if changed < size/20 || changed > size/5 {
+			t.Fatalf("%s: %d of %d bytes changed, expected about 10%%", name, changed, size)
+		}
+	}
+
+	// the recorder only supports Write
+	rec := httptest.NewRecorder()
+	mw(dataHandler)(rec, httptest.NewRequest(http.MethodGet, "/?size=10000", nil))
+	check("write", rec.Body.Bytes())
+
+	// io.Copy of the data handler uses ReadFrom of the response writer of
+	// the server
+	srv := httptest.NewServer(mw(dataHandler))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/?size=10000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("read from", body)
+}
+
+func TestNonce(t *testing.T) {
+	cfg, err := config.Parse([]byte(`nonce static{template: true, body: "nonce={{ .Nonce }}"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := buildHanlderChain(cfg["/"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		nonce := rec.Header().Get("X-Nonce")
+		if nonce == "" {
+			t.Fatal("missing X-Nonce header")
+		}
+		if rec.Body.String() != "nonce="+nonce {
+			t.Fatalf("got body %q, want nonce=%s", rec.Body.String(), nonce)
+		}
+		if seen[nonce] {
+			t.Fatalf("nonce %s used twice", nonce)
+		}
+		seen[nonce] = true
+	}
+}
+
+func TestTiming(t *testing.T) {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}
+
+	mw, err := timing(config.Settings{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	mw(slow)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	value, ok := strings.CutPrefix(rec.Header().Get("Server-Timing"), "app;dur=")
+	if !ok {
+		t.Fatalf("invalid Server-Timing header %q", rec.Header().Get("Server-Timing"))
+	}
+	ms, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms < 10 {
+		t.Fatalf("got duration %fms, want at least 10ms", ms)
+	}
+
+	mw, err = timing(config.Settings{"format": {"plain"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	mw(slow)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	d, err := time.ParseDuration(rec.Header().Get("X-Response-Time"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d < 10*time.Millisecond {
+		t.Fatalf("got duration %s, want at least 10ms", d)
+	}
+}
+
+func TestCORSCredentials(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		settings    config.Settings
+		origin      string
+		allowOrigin string
+		credentials string
+	}{
+		{
+			name:        "any origin",
+			settings:    config.Settings{"origins": {"*"}},
+			origin:      "https://a.example.com",
+			allowOrigin: "*",
+		},
+		{
+			name:        "any origin with credentials echoes origin",
+			settings:    config.Settings{"origins": {"*"}, "credentials": {"true"}},
+			origin:      "https://a.example.com",
+			allowOrigin: "https://a.example.com",
+			credentials: "true",
+		},
+		{
+			name:        "matched origin with credentials",
+			settings:    config.Settings{"origins": {"https://a.example.com,https://b.example.com"}, "credentials": {"true"}},
+			origin:      "https://b.example.com",
+			allowOrigin: "https://b.example.com",
+			credentials: "true",
+		},
+		{
+			name:     "unknown origin",
+			settings: config.Settings{"origins": {"https://a.example.com"}, "credentials": {"true"}},
+			origin:   "https://evil.example.com",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mw, err := cors(test.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Origin", test.origin)
+			rec := httptest.NewRecorder()
+			mw(newStaticResponseHandler().ServeHTTP)(rec, req)
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, test.allowOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != test.credentials {
+				t.Errorf("got Access-Control-Allow-Credentials %q, want %q", got, test.credentials)
+			}
+		})
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	handlerCalled := false
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+	}
+
+	for _, test := range []struct {
+		name           string
+		settings       config.Settings
+		origin         string
+		requestHeaders string
+		allowOrigin    string
+		allowMethods   string
+		allowHeaders   string
+	}{
+		{
+			name:           "configured methods and headers",
+			settings:       config.Settings{"origins": {"*"}, "methods": {"GET, PUT"}, "headers": {"Content-Type, X-Token"}},
+			origin:         "https://a.example.com",
+			requestHeaders: "X-Token",
+			allowOrigin:    "*",
+			allowMethods:   "GET, PUT",
+			allowHeaders:   "Content-Type, X-Token",
+		},
+		{
+			name:           "requested method and headers",
+			settings:       config.Settings{"origins": {"https://a.example.com"}},
+			origin:         "https://a.example.com",
+			requestHeaders: "X-Foo",
+			allowOrigin:    "https://a.example.com",
+			allowMethods:   "PUT",
+			allowHeaders:   "X-Foo",
+		},
+		{
+			name:     "unknown origin",
+			settings: config.Settings{"origins": {"https://a.example.com"}},
+			origin:   "https://evil.example.com",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			handlerCalled = false
+			mw, err := cors(test.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodOptions, "/", nil)
+			req.Header.Set("Origin", test.origin)
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+			if test.requestHeaders != "" {
+				req.Header.Set("Access-Control-Request-Headers", test.requestHeaders)
+			}
+			rec := httptest.NewRecorder()
+			mw(handler)(rec, req)
+			if handlerCalled {
+				t.Error("preflight request was passed to the handler")
+			}
+			if rec.Code != http.StatusNoContent {
+				t.Errorf("got %d, want %d", rec.Code, http.StatusNoContent)
+			}
+			for header, expected := range map[string]string{
+				"Access-Control-Allow-Origin":  test.allowOrigin,
+				"Access-Control-Allow-Methods": test.allowMethods,
+				"Access-Control-Allow-Headers": test.allowHeaders,
+			} {
+				if got := rec.Header().Get(header); got != expected {
+					t.Errorf("got %s %q, want %q", header, got, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderOutMultiValue(t *testing.T) {
+	cfg, err := config.Parse([]byte("header-out{Set-Cookie: a=1, Set-Cookie: b=2, X-Test: c} static"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := buildHanlderChain(cfg["/"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	for key, expected := range map[string][]string{
+		"Set-Cookie": {"a=1", "b=2"},
+		"X-Test":     {"c"},
+	} {
+		if got := rec.Header().Values(key); !reflect.DeepEqual(got, expected) {
+			t.Errorf("got %s %v, want %v", key, got, expected)
+		}
+	}
+}
+
+func TestHeaderOutSetRemove(t *testing.T) {
+	cfg, err := config.Parse([]byte(`header-out{X-Add: a, =X-Set: mw, -Date: "", -Content-Type: "", -X-Remove: ""} static{body: hello}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := buildHanlderChain(cfg["/"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Set", "handler")
+		w.Header().Set("X-Remove", "handler")
+		chain.ServeHTTP(w, r)
+	})
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	for key, expected := range map[string][]string{
+		"X-Add":        {"a"},
+		"X-Set":        {"mw"},
+		"X-Remove":     nil,
+		"Date":         nil,
+		"Content-Type": nil,
+	} {
+		if got := resp.Header.Values(key); !reflect.DeepEqual(got, expected) {
+			t.Errorf("got %s %v, want %v", key, got, expected)
+		}
+	}
+
+	if _, err := headerOut(config.Settings{"=X-Test": {"a"}, "-X-Test": {""}}); err == nil {
+		t.Error("expected error for setting and removing the same header")
+	}
+}
+
+func TestRequireHeaders(t *testing.T) {
+	mw, err := requireHeaders(config.Settings{"headers": {"X-Request-Id, X-Api-Version=v[12]"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := mw(newStaticResponseHandler().ServeHTTP)
+
+	for _, test := range []struct {
+		header http.Header
+		code   int
+		body   string
+	}{
+		{
+			header: http.Header{"X-Request-Id": {"1"}, "X-Api-Version": {"v1"}},
+			code:   http.StatusOK,
+			body:   "ok\n",
+		},
+		{
+			header: http.Header{"X-Api-Version": {"v1"}},
+			code:   http.StatusBadRequest,
+			body:   "missing required headers: X-Request-Id\n",
+		},
+		{
+			header: http.Header{"X-Request-Id": {"1"}, "X-Api-Version": {"v3"}},
+			code:   http.StatusBadRequest,
+			body:   "invalid values for headers: X-Api-Version\n",
+		},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header = test.header
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != test.code || rec.Body.String() != test.body {
+			t.Errorf("%v: got %d %q, want %d %q", test.header, rec.Code, rec.Body.String(), test.code, test.body)
+		}
+	}
+}
+
+func TestConnectionClose(t *testing.T) {
+	var newConns atomic.Int64
+	srv := httptest.NewUnstartedServer(connectionClose(newStaticResponseHandler().ServeHTTP))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	client := srv.Client()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if !resp.Close {
+			t.Fatal("response does not indicate Connection: close")
+		}
+	}
+	if newConns.Load() != 3 {
+		t.Fatalf("got %d connections, want 3", newConns.Load())
+	}
+}
+
+func TestBearerAuth(t *testing.T) {
+	mw, err := bearerAuth(config.Settings{"tokens": {"secret1, secret2"}, "realm": {"test"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := mw(newStaticResponseHandler().ServeHTTP)
+
+	for _, test := range []struct {
+		auth            string
+		code            int
+		wwwAuthenticate string
+	}{
+		{
+			auth: "Bearer secret2",
+			code: http.StatusOK,
+		},
+		{
+			auth:            "",
+			code:            http.StatusUnauthorized,
+			wwwAuthenticate: `Bearer realm="test"`,
+		},
+		{
+			auth:            "Basic dXNlcjpwYXNz",
+			code:            http.StatusUnauthorized,
+			wwwAuthenticate: `Bearer realm="test"`,
+		},
+		{
+			auth:            "Bearer wrong",
+			code:            http.StatusUnauthorized,
+			wwwAuthenticate: `Bearer realm="test", error="invalid_token", error_description="the access token is invalid"`,
+		},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != test.code {
+			t.Errorf("%q: got code %d, want %d", test.auth, rec.Code, test.code)
+		}
+		if got := rec.Header().Get("WWW-Authenticate"); got != test.wwwAuthenticate {
+			t.Errorf("%q: got WWW-Authenticate %q, want %q", test.auth, got, test.wwwAuthenticate)
+		}
+	}
+}
+
+func TestAuthToken(t *testing.T) {
+	mw, err := middlewares["auth-token"](config.Settings{"token": {"secret1,secret2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := mw(newStaticResponseHandler().ServeHTTP)
+	for auth, code := range map[string]int{
+		"Bearer secret1": http.StatusOK,
+		"Bearer secret2": http.StatusOK,
+		"Bearer secret3": http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != code {
+			t.Errorf("%q: got code %d, want %d", auth, rec.Code, code)
+		}
+	}
+}
+
+func TestMaxURILength(t *testing.T) {
+	mw, err := maxURILength(config.Settings{"max": {"10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := mw(newStaticResponseHandler().ServeHTTP)
+
+	for _, test := range []struct {
+		uri  string
+		code int
+	}{
+		{"/?a=123456", http.StatusOK},
+		{"/?a=1234567", http.StatusRequestURITooLong},
+	} {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, test.uri, nil))
+		if rec.Code != test.code {
+			t.Errorf("%s: got %d, want %d", test.uri, rec.Code, test.code)
+		}
+	}
+}
+
+func TestLogRequestJSON(t *testing.T) {
+	err := setLogFormat(logFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setLogFormat(logFormatText)
+
+	logOutput := &bytes.Buffer{}
+	jsonLog.SetOutput(logOutput)
+	defer jsonLog.SetOutput(os.Stderr)
+
+	rec := httptest.NewRecorder()
+	logRequest(newStaticResponseHandler().ServeHTTP)(rec, httptest.NewRequest(http.MethodGet, "/foo?bar=1", nil))
+
+	entry := map[string]any{}
+	err = json.Unmarshal(logOutput.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("log output %q is not valid JSON: %s", logOutput.String(), err)
+	}
+	for key, expected := range map[string]any{
+		"src":     "192.0.2.1:1234",
+		"method":  "GET",
+		"proto":   "HTTP/1.1",
+		"url":     "/foo?bar=1",
+		"code":    float64(200),
+		"written": float64(3),
+	} {
+		if entry[key] != expected {
+			t.Errorf("got %s %v, want %v", key, entry[key], expected)
+		}
+	}
+	if _, ok := entry["dt"].(float64); !ok {
+		t.Errorf("dt is not a number: %v", entry["dt"])
+	}
+
+	err = setLogFormat("xml")
+	if err == nil {
+		t.Fatal("expected error for invalid format")
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	logOutput := &bytes.Buffer{}
+	log.SetOutput(logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	rec := httptest.NewRecorder()
+	recoverPanic(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if !strings.Contains(logOutput.String(), "panic: method=GET url=/: boom") || !strings.Contains(logOutput.String(), "goroutine") {
+		t.Fatalf("panic and stack not logged: %q", logOutput.String())
+	}
+
+	// headers already sent
+	rec = httptest.NewRecorder()
+	recoverPanic(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		panic("boom")
+	})(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusAccepted || rec.Body.String() != "partial" {
+		t.Fatalf("got %d %q, want %d %q", rec.Code, rec.Body.String(), http.StatusAccepted, "partial")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	logOutput := &bytes.Buffer{}
+	log.SetOutput(logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	// generated, logged in both orders
+	for _, chain := range []string{"request-id log static", "log request-id static"} {
+		logOutput.Reset()
+		cfg, err := config.Parse([]byte(chain))
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler, err := buildHanlderChain(cfg["/"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		id := rec.Header().Get("X-Request-Id")
+		if len(id) != 32 {
+			t.Fatalf("%s: got invalid generated request id %q", chain, id)
+		}
+		if !strings.Contains(logOutput.String(), " request_id="+id) {
+			t.Fatalf("%s: request id not logged: %q", chain, logOutput.String())
+		}
+	}
+
+	// from request with custom header
+	mw, err := requestID(config.Settings{"header": {"X-Correlation-Id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromContext string
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Correlation-Id", "abc")
+	rec := httptest.NewRecorder()
+	mw(func(w http.ResponseWriter, r *http.Request) {
+		fromContext = requestIDFromContext(r.Context())
+	})(rec, req)
+	if fromContext != "abc" || rec.Header().Get("X-Correlation-Id") != "abc" {
+		t.Fatalf("got context %q header %q, want abc", fromContext, rec.Header().Get("X-Correlation-Id"))
+	}
+}
+
+func TestDelay(t *testing.T) {
+	mw, err := delay(config.Settings{"duration": {"50ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	handler := mw(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	start := time.Now()
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("request was delayed by %s, want at least 50ms", elapsed)
+	}
+	if !called {
+		t.Fatal("handler was not called")
+	}
+
+	// a canceled request is not passed to the next handler
+	called = false
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if called {
+		t.Fatal("handler was called for canceled request")
+	}
+}
+
+func TestFault(t *testing.T) {
+	run := func() []int {
+		mw, err := fault(config.Settings{"rate": {"0.5"}, "code": {"503"}, "body": {"unavailable"}, "seed": {"42"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := mw(newStaticResponseHandler().ServeHTTP)
+		codes := []int{}
+		for i := 0; i < 100; i++ {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code == http.StatusServiceUnavailable && rec.Body.String() != "unavailable" {
+				t.Fatalf("got body %q, want unavailable", rec.Body.String())
+			}
+			codes = append(codes, rec.Code)
+		}
+		return codes
+	}
+
+	codes := run()
+	faults := 0
+	for _, code := range codes {
+		if code == http.StatusServiceUnavailable {
+			faults++
+		}
+	}
+	if faults < 30 || faults > 70 {
+		t.Fatalf("got %d faults in 100 requests, want about 50", faults)
+	}
+	if !reflect.DeepEqual(codes, run()) {
+		t.Fatal("got different faults for the same seed")
+	}
+
+	for _, settings := range []config.Settings{
+		{},
+		{"rate": {"2"}},
+		{"rate": {"0.5"}, "code": {"abc"}},
+		{"rate": {"0.5"}, "code": {"600"}},
+		{"rate": {"0.5"}, "seed": {"-1"}},
+	} {
+		if _, err := fault(settings); err == nil {
+			t.Errorf("%v: expected error", settings)
+		}
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	mw, err := jsonLogger(config.Settings{"max": {"20"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	handler := mw(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+	})
+
+	for _, test := range []struct {
+		name    string
+		body    string
+		chunked bool
+		output  string
+	}{
+		{"small", `{"a":1}`, false, "{\n  \"a\": 1\n}\n"},
+		{"chunked", `{"a":1}`, true, "{\n  \"a\": 1\n}\n"},
+		{"too large", `{"a":"01234567890123456789"}`, false, ""},
+		{"chunked too large", `{"a":"01234567890123456789"}`, true, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			if test.chunked {
+				req.ContentLength = -1
+			}
+			output := captureStdout(t, func() {
+				handler(httptest.NewRecorder(), req)
+			})
+			if string(got) != test.body {
+				t.Errorf("got body %q, want %q", got, test.body)
+			}
+			if output != test.output {
+				t.Errorf("got output %q, want %q", output, test.output)
+			}
+		})
+	}
+}
+
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestRespond(t *testing.T) {
+	mw, err := respond(config.Settings{"code": {"418"}, "body": {"teapot"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := mw(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("next handler was called")
+	})
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTeapot || rec.Body.String() != "teapot" {
+		t.Fatalf("got %d %q, want 418 teapot", rec.Code, rec.Body.String())
+	}
+
+	for _, code := range []string{"", "abc", "99", "600"} {
+		if _, err := respond(config.Settings{"code": {code}}); err == nil {
+			t.Errorf("%q: expected error", code)
+		}
+	}
+	if _, err := respond(config.Settings{}); err == nil {
+		t.Error("expected error for missing code")
+	}
+}
+
+func TestDumpResponse(t *testing.T) {
+	logOutput := &bytes.Buffer{}
+	log.SetOutput(logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	for _, test := range []struct {
+		name     string
+		settings config.Settings
+		logged   string
+		notLog   string
+	}{
+		{"headers", config.Settings{}, "HTTP/1.1 201 Created\r\nX-Test: 1\r\n\r\n", "hello"},
+		{"body", config.Settings{"body": {"true"}, "max": {"3"}}, "X-Test: 1\r\n\r\nhel", "hello"},
+		{"no buffer", config.Settings{"body": {"true"}, "no-buffer": {"true"}}, "HTTP/1.1 201 Created\r\nX-Test: 1\r\n\r\nhello world", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			logOutput.Reset()
+			mw, err := dumpResponse(test.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			handler := mw(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Test", "1")
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, "hello")
+				io.WriteString(w, " world")
+			})
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != http.StatusCreated || rec.Body.String() != "hello world" || rec.Header().Get("X-Test") != "1" {
+				t.Fatalf("unexpected response: %d %q %v", rec.Code, rec.Body.String(), rec.Header())
+			}
+			if !strings.Contains(logOutput.String(), test.logged) {
+				t.Fatalf("log %q does not contain %q", logOutput.String(), test.logged)
+			}
+			if test.notLog != "" && strings.Contains(logOutput.String(), test.notLog) {
+				t.Fatalf("log %q contains %q", logOutput.String(), test.notLog)
+			}
+		})
+	}
+}
+
+func TestDumpResponseReadFrom(t *testing.T) {
+	logOutput := &bytes.Buffer{}
+	log.SetOutput(logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	mw, err := dumpResponse(config.Settings{"body": {"true"}, "max": {"5"}, "no-buffer": {"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// io.Copy in the data handler uses ReadFrom of the response writer
+	srv := httptest.NewServer(mw(dataHandler))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/?size=10&fill=hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hellohello" {
+		t.Fatalf("got body %q", body)
+	}
+	if !strings.Contains(logOutput.String(), "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(logOutput.String(), "\r\n\r\nhello\n") {
+		t.Fatalf("unexpected log %q", logOutput.String())
+	}
+}
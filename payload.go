@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dvob/http-server/config"
+)
+
+const loremIpsum = "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat. "
+
+// payloadFormat describes how a payload of a certain type is generated. The
+// body consists of header, items separated by separator and footer. The
+// remaining space is filled with padding so that the body has exactly the
+// requested size.
+type payloadFormat struct {
+	contentType string
+	header      string
+	footer      string
+	separator   string
+	item        func(i int) string
+	padding     byte
+}
+
+var payloadFormats = map[string]payloadFormat{
+	"text": {
+		contentType: "text/plain; charset=utf-8",
+		item:        func(int) string { return loremIpsum },
+	},
+	"json": {
+		contentType: "application/json",
+		header:      "[",
+		footer:      "]",
+		separator:   ",\n",
+		item: func(i int) string {
+			return `{"id":` + strconv.Itoa(i) + `,"name":"item ` + strconv.Itoa(i) + `","active":true,"text":"` + strings.TrimSpace(loremIpsum) + `"}`
+		},
+		padding: ' ',
+	},
+	"html": {
+		contentType: "text/html; charset=utf-8",
+		header:      "<!DOCTYPE html>\n<html>\n<head><title>payload</title></head>\n<body>\n",
+		footer:      "</body>\n</html>\n",
+		separator:   "\n",
+		item: func(i int) string {
+			return "<h2>Section " + strconv.Itoa(i) + "</h2>\n<p>" + strings.TrimSpace(loremIpsum) + "</p>"
+		},
+		padding: ' ',
+	},
+}
+
+// payloadHandler returns a body of size bytes with realistic content of the
+// configured type.
+type payloadHandler struct {
+	size   int
+	format payloadFormat
+}
+
+func newPayloadHandler(config config.Settings) (http.Handler, error) {
+	size, err := config.Int("size", 1024)
+	if err != nil {
+		return nil, err
+	}
+	typ := "text"
+	if t, ok := config.Lookup("type"); ok {
+		typ = t
+	}
+	format, ok := payloadFormats[typ]
+	if !ok {
+		return nil, fmt.Errorf("invalid type '%s': must be text, json or html", typ)
+	}
+	if size < len(format.header)+len(format.footer) {
+		return nil, fmt.Errorf("size %d is too small for type %s", size, typ)
+	}
+	return &payloadHandler{
+		size:   size,
+		format: format,
+	}, nil
+}
+
+func (p *payloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", p.format.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(p.size))
+	if r.Method == http.MethodHead {
+		return
+	}
+	buf := bufio.NewWriter(w)
+	err := p.format.write(buf, p.size)
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func (f payloadFormat) write(w io.StringWriter, size int) error {
+	remaining := size - len(f.header) - len(f.footer)
+	_, err := w.WriteString(f.header)
+	if err != nil {
+		return err
+	}
+	for i := 0; remaining > 0; i++ {
+		item := f.item(i)
+		if i > 0 {
+			item = f.separator + item
+		}
+		if len(item) > remaining {
+			// without padding (text) we cut the item
+			if f.padding == 0 {
+				item = item[:remaining]
+			} else {
+				break
+			}
+		}
+		_, err = w.WriteString(item)
+		if err != nil {
+			return err
+		}
+		remaining -= len(item)
+	}
+	if remaining > 0 {
+		_, err = w.WriteString(strings.Repeat(string(f.padding), remaining))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.WriteString(f.footer)
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dvob/http-server/config"
+)
+
+func TestPayload(t *testing.T) {
+	for _, typ := range []string{"text", "json", "html"} {
+		for _, size := range []int{100, 1000, 12345} {
+			h, err := handlers["payload"](config.Settings{"type": {typ}, "size": {strconv.Itoa(size)}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			body := rec.Body.Bytes()
+			if len(body) != size {
+				t.Fatalf("%s: got %d bytes, want %d", typ, len(body), size)
+			}
+
+			switch typ {
+			case "json":
+				items := []map[string]any{}
+				err := json.Unmarshal(body, &items)
+				if err != nil {
+					t.Fatalf("json with size %d is invalid: %s", size, err)
+				}
+			case "html":
+				if !strings.HasPrefix(string(body), "<!DOCTYPE html>") || !strings.HasSuffix(string(body), "</html>\n") {
+					t.Fatalf("html with size %d is invalid", size)
+				}
+			}
+		}
+	}
+}
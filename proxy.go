@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dvob/http-server/config"
+)
+
+// transportPool configures the connection pool of the upstream transports.
+type transportPool struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+	idleConnTimeout     time.Duration
+}
+
+func newDefaultTransportPool() transportPool {
+	return transportPool{
+		maxIdleConns:        100,
+		maxIdleConnsPerHost: 10,
+		maxConnsPerHost:     100,
+		idleConnTimeout:     90 * time.Second,
+	}
+}
+
+func newTransportPool(config config.Settings) (transportPool, error) {
+	var err error
+	p := newDefaultTransportPool()
+	p.maxIdleConns, err = config.Int("max-idle-conns", p.maxIdleConns)
+	if err != nil {
+		return p, err
+	}
+	p.maxIdleConnsPerHost, err = config.Int("max-idle-conns-per-host", p.maxIdleConnsPerHost)
+	if err != nil {
+		return p, err
+	}
+	p.maxConnsPerHost, err = config.Int("max-conns-per-host", p.maxConnsPerHost)
+	if err != nil {
+		return p, err
+	}
+	p.idleConnTimeout, err = config.Duration("idle-conn-timeout", p.idleConnTimeout)
+	if err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+func (p transportPool) apply(t *http.Transport) {
+	t.MaxIdleConns = p.maxIdleConns
+	t.MaxIdleConnsPerHost = p.maxIdleConnsPerHost
+	t.MaxConnsPerHost = p.maxConnsPerHost
+	t.IdleConnTimeout = p.idleConnTimeout
+}
+
+func newProxyHandler(config config.Settings) (http.Handler, error) {
+	targets, err := newTargetSelector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := newTransportPool(config)
+	if err != nil {
+		return nil, err
+	}
+
+	responseDelay, err := config.Duration("response-delay", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	inspect, err := config.Bool("inspect", false)
+	if err != nil {
+		return nil, err
+	}
+	redact := append(slices.Clone(sensitiveHeaders), config.List("redact")...)
+
+	upstreamHeader, err := newUpstreamHeader(config)
+	if err != nil {
+		return nil, err
+	}
+
+	preserveHost, err := config.Bool("preserve-host", false)
+	if err != nil {
+		return nil, err
+	}
+
+	stripPrefix := strings.TrimSuffix(config.Get("strip-prefix"), "/")
+
+	wsOrigin, setWSOrigin := config.Lookup("ws-origin")
+	wsProtocol, setWSProtocol := config.Lookup("ws-protocol")
+
+	rewriteFunc := func(pr *httputil.ProxyRequest) {
+		if stripPrefix != "" {
+			pr.Out.URL.Path = trimPathPrefix(pr.Out.URL.Path, stripPrefix)
+			pr.Out.URL.RawPath = trimPathPrefix(pr.Out.URL.RawPath, stripPrefix)
+		}
+		pr.SetURL(pr.In.Context().Value(proxyTargetKey{}).(*url.URL))
+		pr.SetXForwarded()
+		// SetURL sets the host of the target
+		if preserveHost {
+			pr.Out.Host = pr.In.Host
+		}
+		if targets.header != "" {
+			pr.Out.Header.Del(targets.header)
+		}
+
+		// some websocket backends reject mismatched origins or subprotocols
+		if pr.In.Header.Get("Upgrade") != "" {
+			if setWSOrigin {
+				pr.Out.Header.Set("Origin", wsOrigin)
+			}
+			if setWSProtocol {
+				pr.Out.Header.Set("Sec-WebSocket-Protocol", wsProtocol)
+			}
+		}
+
+		for name, values := range upstreamHeader {
+			pr.Out.Header[name] = values
+		}
+
+		if inspect {
+			logOutgoingRequest(pr, redact)
+		}
+	}
+
+	responseModifiers := []func(*http.Response) error{}
+
+	cookies, err := newCookieRewriter(config)
+	if err != nil {
+		return nil, err
+	}
+	if cookies != nil {
+		responseModifiers = append(responseModifiers, cookies.modifyResponse)
+	}
+
+	// simulate a slow backend after the upstream has responded
+	if responseDelay > 0 {
+		responseModifiers = append(responseModifiers, func(resp *http.Response) error {
+			select {
+			case <-time.After(responseDelay):
+				return nil
+			case <-resp.Request.Context().Done():
+				return resp.Request.Context().Err()
+			}
+		})
+	}
+
+	var modifyResponse func(*http.Response) error
+	if len(responseModifiers) > 0 {
+		modifyResponse = func(resp *http.Response) error {
+			for _, modify := range responseModifiers {
+				err := modify(resp)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	upstreamTLSConfig, err := newUpstreamTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare reverse proxy for HTTP/1.1
+	http11Transport := http.DefaultTransport.(*http.Transport).Clone()
+	http11Transport.ForceAttemptHTTP2 = false
+	http11Transport.TLSNextProto = make(map[string]func(authority string, c *tls.Conn) http.RoundTripper)
+	http11Transport.TLSClientConfig = upstreamTLSConfig.Clone()
+	pool.apply(http11Transport)
+
+	http11Upstream := &httputil.ReverseProxy{
+		Rewrite:        rewriteFunc,
+		Transport:      http11Transport,
+		ModifyResponse: modifyResponse,
+	}
+
+	// prepare default reverse proxy which uses HTTP/2 if the upstream supports it
+	defaultTransport := http.DefaultTransport.(*http.Transport).Clone()
+	defaultTransport.TLSClientConfig = upstreamTLSConfig.Clone()
+	pool.apply(defaultTransport)
+
+	defaultUpstream := &httputil.ReverseProxy{
+		Rewrite:        rewriteFunc,
+		Transport:      defaultTransport,
+		ModifyResponse: modifyResponse,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, err := targets.pick(w, r)
+		if err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), proxyTargetKey{}, target))
+
+		// Upgrade is only supported by HTTP/1.1
+		if r.Proto == "HTTP/1.1" && r.Header.Get("Upgrade") != "" {
+			http11Upstream.ServeHTTP(w, r)
+		} else {
+			defaultUpstream.ServeHTTP(w, r)
+		}
+	}), nil
+}
+
+// trimPathPrefix removes prefix from path if path is prefix or starts with
+// prefix followed by a slash. Otherwise path is returned unchanged.
+func trimPathPrefix(path, prefix string) string {
+	if path == prefix {
+		return "/"
+	}
+	if strings.HasPrefix(path, prefix+"/") {
+		return path[len(prefix):]
+	}
+	return path
+}
+
+// newUpstreamTLSConfig returns the TLS configuration for the connections to
+// the upstream. With insecure: true the certificate of the upstream is not
+// verified. With ca the certificates in the PEM file are used as root CAs
+// instead of the system pool.
+func newUpstreamTLSConfig(config config.Settings) (*tls.Config, error) {
+	insecure, err := config.Bool("insecure", false)
+	if err != nil {
+		return nil, err
+	}
+	caFile, hasCA := config.Lookup("ca")
+	if insecure && hasCA {
+		return nil, fmt.Errorf("'insecure' and 'ca' can not be used together")
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+	if hasCA {
+		pemCerts, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("no certificates found in ca %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// newUpstreamHeader returns the headers which are set on each request to the
+// upstream and replace the headers sent by the client:
+//   - upstream-header: header in the form 'Name: value'
+//   - upstream-auth: value of the Authorization header (e.g. 'Bearer abc')
+//   - upstream-auth-file: file which contains the value of the Authorization header
+//   - upstream-auth-env: environment variable which contains the value of the Authorization header
+func newUpstreamHeader(config config.Settings) (http.Header, error) {
+	header := http.Header{}
+	for _, h := range config["upstream-header"] {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid upstream-header '%s': expected 'Name: value'", h)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	if auth, ok := config.Lookup("upstream-auth"); ok {
+		header.Set("Authorization", auth)
+	}
+	if file, ok := config.Lookup("upstream-auth-file"); ok {
+		auth, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upstream-auth-file: %w", err)
+		}
+		header.Set("Authorization", strings.TrimSpace(string(auth)))
+	}
+	if env, ok := config.Lookup("upstream-auth-env"); ok {
+		auth, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("environment variable '%s' of upstream-auth-env is not set", env)
+		}
+		header.Set("Authorization", auth)
+	}
+	return header, nil
+}
+
+// sensitiveHeaders are redacted when outgoing requests are logged.
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// logOutgoingRequest logs the rewritten request which is sent to the
+// upstream. The values of the headers in redact are replaced.
+func logOutgoingRequest(pr *httputil.ProxyRequest, redact []string) {
+	header := pr.Out.Header.Clone()
+	for _, name := range redact {
+		if _, ok := header[http.CanonicalHeaderKey(name)]; ok {
+			header.Set(name, "REDACTED")
+		}
+	}
+	headers := []string{}
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		for _, value := range header[name] {
+			headers = append(headers, name+": "+value)
+		}
+	}
+	id := requestIDFromContext(pr.In.Context())
+	if id == "" {
+		id = pr.In.Header.Get("X-Request-Id")
+	}
+	log.Printf(
+		"proxy request_id=%s method=%s url=%s host=%s headers=%q",
+		id,
+		pr.Out.Method,
+		pr.Out.URL,
+		pr.Out.Host,
+		strings.Join(headers, ", "),
+	)
+}
+
+// proxyTargetKey is the context key of the target selected for a request.
+type proxyTargetKey struct{}
+
+// targetSelector selects one of multiple targets for each request. Without
+// affinity the targets are used round-robin. With affinity the same client
+// (by IP or by cookie) is always sent to the same target based on a hash of
+// the client key. If header is set and the request contains the header, the
+// target from the header is used if it is one of the configured targets.
+type targetSelector struct {
+	targets    []*url.URL
+	next       atomic.Uint64
+	affinity   string
+	cookieName string
+	header     string
+}
+
+func newTargetSelector(config config.Settings) (*targetSelector, error) {
+	if len(config["target"]) == 0 {
+		return nil, fmt.Errorf("missing configuration 'target'")
+	}
+	s := &targetSelector{
+		affinity:   config.Get("affinity"),
+		cookieName: "http-server-affinity",
+	}
+	if name, ok := config.Lookup("affinity-cookie-name"); ok {
+		s.cookieName = name
+	}
+	s.header = config.Get("target-header")
+	if s.affinity != "" && s.affinity != "ip" && s.affinity != "cookie" {
+		return nil, fmt.Errorf("invalid affinity '%s': must be ip or cookie", s.affinity)
+	}
+	for _, target := range config["target"] {
+		targetURL, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		s.targets = append(s.targets, targetURL)
+	}
+	return s, nil
+}
+
+func (s *targetSelector) roundRobin() int {
+	return int((s.next.Add(1) - 1) % uint64(len(s.targets)))
+}
+
+// session returns the target index of key. The index is derived from a hash
+// of the key, so no state has to be kept per client.
+func (s *targetSelector) session(key string) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return int(h.Sum64() % uint64(len(s.targets)))
+}
+
+func (s *targetSelector) pick(w http.ResponseWriter, r *http.Request) (*url.URL, error) {
+	if s.header != "" {
+		if target := r.Header.Get(s.header); target != "" {
+			for _, allowed := range s.targets {
+				if allowed.String() == target {
+					return allowed, nil
+				}
+			}
+			return nil, fmt.Errorf("target '%s' from header %s is not allowed", target, s.header)
+		}
+	}
+
+	if len(s.targets) == 1 {
+		return s.targets[0], nil
+	}
+
+	switch s.affinity {
+	case "ip":
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		return s.targets[s.session(ip)], nil
+	case "cookie":
+		cookie, err := r.Cookie(s.cookieName)
+		if err == nil && cookie.Value != "" {
+			return s.targets[s.session(cookie.Value)], nil
+		}
+		id := newSessionID()
+		http.SetCookie(w, &http.Cookie{
+			Name:     s.cookieName,
+			Value:    id,
+			Path:     "/",
+			HttpOnly: true,
+		})
+		return s.targets[s.session(id)], nil
+	default:
+		return s.targets[s.roundRobin()], nil
+	}
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// cookieRewriter rewrites the attributes of the Set-Cookie headers of the
+// upstream responses. This is required if the proxy runs on a different
+// domain than the upstream.
+type cookieRewriter struct {
+	// domain replaces the Domain attribute. an empty domain removes the
+	// attribute which binds the cookie to the host of the proxy.
+	domain   *string
+	path     *string
+	secure   *bool
+	sameSite http.SameSite
+}
+
+func newCookieRewriter(config config.Settings) (*cookieRewriter, error) {
+	c := &cookieRewriter{}
+	configured := false
+	if domain, ok := config.Lookup("cookie-domain"); ok {
+		c.domain = &domain
+		configured = true
+	}
+	if path, ok := config.Lookup("cookie-path"); ok {
+		c.path = &path
+		configured = true
+	}
+	if _, ok := config.Lookup("cookie-secure"); ok {
+		secure, err := config.Bool("cookie-secure", false)
+		if err != nil {
+			return nil, err
+		}
+		c.secure = &secure
+		configured = true
+	}
+	if sameSite, ok := config.Lookup("cookie-samesite"); ok {
+		switch strings.ToLower(sameSite) {
+		case "lax":
+			c.sameSite = http.SameSiteLaxMode
+		case "strict":
+			c.sameSite = http.SameSiteStrictMode
+		case "none":
+			c.sameSite = http.SameSiteNoneMode
+		default:
+			return nil, fmt.Errorf("invalid cookie-samesite '%s': must be lax, strict or none", sameSite)
+		}
+		configured = true
+	}
+	if !configured {
+		return nil, nil
+	}
+	return c, nil
+}
+
+func (c *cookieRewriter) modifyResponse(resp *http.Response) error {
+	setCookies := resp.Header.Values("Set-Cookie")
+	if len(setCookies) == 0 {
+		return nil
+	}
+	resp.Header.Del("Set-Cookie")
+	for _, setCookie := range setCookies {
+		cookie, err := http.ParseSetCookie(setCookie)
+		if err != nil {
+			// keep what we can't parse
+			resp.Header.Add("Set-Cookie", setCookie)
+			continue
+		}
+		if c.domain != nil {
+			cookie.Domain = *c.domain
+		}
+		if c.path != nil {
+			cookie.Path = *c.path
+		}
+		if c.secure != nil {
+			cookie.Secure = *c.secure
+		}
+		if c.sameSite != 0 {
+			cookie.SameSite = c.sameSite
+		}
+		resp.Header.Add("Set-Cookie", cookie.String())
+	}
+	return nil
+}
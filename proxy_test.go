@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dvob/http-server/config"
+)
+
+func TestProxyWebSocketHeaders(t *testing.T) {
+	var origin, protocol string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin = r.Header.Get("Origin")
+		protocol = r.Header.Get("Sec-WebSocket-Protocol")
+	}))
+	defer upstream.Close()
+
+	proxy, err := handlers["proxy"](config.Settings{
+		"target":      {upstream.URL},
+		"ws-origin":   {"https://backend.example.com"},
+		"ws-protocol": {"chat"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// regular request is not modified
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://client.example.com")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+	if origin != "https://client.example.com" || protocol != "" {
+		t.Fatalf("unexpected headers on regular request: origin=%q protocol=%q", origin, protocol)
+	}
+
+	// upgrade request
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Origin", "https://client.example.com")
+	req.Header.Set("Sec-WebSocket-Protocol", "other")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+	if origin != "https://backend.example.com" {
+		t.Fatalf("got origin %q, want https://backend.example.com", origin)
+	}
+	if protocol != "chat" {
+		t.Fatalf("got protocol %q, want chat", protocol)
+	}
+}
+
+func TestProxyMaxConnsPerHost(t *testing.T) {
+	var active, maxActive atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := active.Add(1)
+		defer active.Add(-1)
+		for {
+			old := maxActive.Load()
+			if current <= old || maxActive.CompareAndSwap(old, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer upstream.Close()
+
+	proxy, err := handlers["proxy"](config.Settings{
+		"target":             {upstream.URL},
+		"max-conns-per-host": {"2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("got %d, want %d", rec.Code, http.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxActive.Load() > 2 {
+		t.Fatalf("upstream saw %d concurrent requests, want at most 2", maxActive.Load())
+	}
+}
+
+func TestProxyInvalidPoolSettings(t *testing.T) {
+	_, err := handlers["proxy"](config.Settings{
+		"target":            {"http://localhost"},
+		"idle-conn-timeout": {"forever"},
+	})
+	if err == nil {
+		t.Fatal("expected error for invalid idle-conn-timeout")
+	}
+}
+
+func newNamedUpstream(t *testing.T, name string) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProxyAffinity(t *testing.T) {
+	a := newNamedUpstream(t, "a")
+	b := newNamedUpstream(t, "b")
+
+	t.Run("round-robin", func(t *testing.T) {
+		proxy, err := handlers["proxy"](config.Settings{"target": {a.URL, b.URL}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		for i := 0; i < 4; i++ {
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			got += rec.Body.String()
+		}
+		if got != "abab" {
+			t.Fatalf("got %s, want abab", got)
+		}
+	})
+
+	t.Run("ip", func(t *testing.T) {
+		proxy, err := handlers["proxy"](config.Settings{"target": {a.URL, b.URL}, "affinity": {"ip"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, client := range []string{"192.0.2.1", "192.0.2.2"} {
+			first := ""
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				req.RemoteAddr = client + ":" + strconv.Itoa(1000+i)
+				rec := httptest.NewRecorder()
+				proxy.ServeHTTP(rec, req)
+				if first == "" {
+					first = rec.Body.String()
+				}
+				if rec.Body.String() != first {
+					t.Fatalf("client %s: got target %s, want %s", client, rec.Body.String(), first)
+				}
+			}
+		}
+	})
+
+	t.Run("cookie", func(t *testing.T) {
+		proxy, err := handlers["proxy"](config.Settings{"target": {a.URL, b.URL}, "affinity": {"cookie"}, "affinity-cookie-name": {"lb"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != "lb" {
+			t.Fatalf("expected affinity cookie lb, got %v", cookies)
+		}
+		first := rec.Body.String()
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.AddCookie(cookies[0])
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, req)
+			if rec.Body.String() != first {
+				t.Fatalf("got target %s, want %s", rec.Body.String(), first)
+			}
+		}
+	})
+}
+
+func TestProxyDeadlineCancelsUpstream(t *testing.T) {
+	upstreamErr := make(chan error, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			upstreamErr <- r.Context().Err()
+		case <-time.After(5 * time.Second):
+			upstreamErr <- nil
+		}
+	}))
+	defer upstream.Close()
+
+	proxy, err := handlers["proxy"](config.Settings{"target": {upstream.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw, err := middlewares["deadline"](config.Settings{"duration": {"50ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	mw(proxy.ServeHTTP)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+
+	select {
+	case err := <-upstreamErr:
+		if err == nil {
+			t.Fatal("upstream request was not cancelled")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+}
+
+func TestProxyResponseDelay(t *testing.T) {
+	upstream := newNamedUpstream(t, "a")
+	proxy, err := handlers["proxy"](config.Settings{"target": {upstream.URL}, "response-delay": {"100ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("response took %s, expected at least 100ms", elapsed)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != "a" {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// client cancellation aborts the delay
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Fatalf("cancelled request took %s", elapsed)
+	}
+}
+
+func TestProxyCookieRewrite(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Domain: "backend.internal", Path: "/app"})
+		http.SetCookie(w, &http.Cookie{Name: "other", Value: "def"})
+	}))
+	defer upstream.Close()
+
+	proxy, err := handlers["proxy"](config.Settings{
+		"target":          {upstream.URL},
+		"cookie-domain":   {"proxy.example.com"},
+		"cookie-path":     {"/"},
+		"cookie-secure":   {"true"},
+		"cookie-samesite": {"strict"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	expected := []string{
+		"session=abc; Path=/; Domain=proxy.example.com; Secure; SameSite=Strict",
+		"other=def; Path=/; Domain=proxy.example.com; Secure; SameSite=Strict",
+	}
+	if got := rec.Header().Values("Set-Cookie"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("got %q, want %q", got, expected)
+	}
+}
+
+func TestProxyTargetHeader(t *testing.T) {
+	a := newNamedUpstream(t, "a")
+	b := newNamedUpstream(t, "b")
+
+	proxy, err := handlers["proxy"](config.Settings{"target": {a.URL, b.URL}, "target-header": {"X-Upstream"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		header string
+		code   int
+		body   string
+	}{
+		{b.URL, http.StatusOK, "b"},
+		{a.URL, http.StatusOK, "a"},
+		{b.URL, http.StatusOK, "b"},
+		{"http://evil.example.com", http.StatusBadRequest, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Upstream", test.header)
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req)
+		if rec.Code != test.code {
+			t.Fatalf("%s: got %d, want %d", test.header, rec.Code, test.code)
+		}
+		if test.code == http.StatusOK && rec.Body.String() != test.body {
+			t.Fatalf("%s: got %q, want %q", test.header, rec.Body.String(), test.body)
+		}
+	}
+}
+
+func TestProxyInspect(t *testing.T) {
+	var path string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer upstream.Close()
+
+	proxy, err := handlers["proxy"](config.Settings{
+		"target":  {upstream.URL + "/base"},
+		"inspect": {"true"},
+		"redact":  {"X-Api-Key"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logOutput := &bytes.Buffer{}
+	log.SetOutput(logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	req := httptest.NewRequest(http.MethodGet, "/foo?x=1", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Api-Key", "secret")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if path != "/base/foo" {
+		t.Fatalf("upstream got path %q, want /base/foo", path)
+	}
+	out := logOutput.String()
+	for _, expected := range []string{
+		"request_id=abc ",
+		"method=GET ",
+		"url=" + upstream.URL + "/base/foo?x=1 ",
+		"Authorization: REDACTED",
+		"X-Api-Key: REDACTED",
+		"X-Forwarded-For: 192.0.2.1",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("log output does not contain %q: %s", expected, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("log output contains sensitive value: %s", out)
+	}
+}
+
+func TestProxyStripPrefix(t *testing.T) {
+	var path string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+	}))
+	defer upstream.Close()
+
+	for _, prefix := range []string{"/api", "/api/"} {
+		proxy, err := handlers["proxy"](config.Settings{"target": {upstream.URL}, "strip-prefix": {prefix}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range []struct {
+			path     string
+			expected string
+		}{
+			{"/api/users", "/users"},
+			{"/api/", "/"},
+			{"/api", "/"},
+			{"/apiary", "/apiary"},
+			{"/other/api", "/other/api"},
+		} {
+			proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, test.path, nil))
+			if path != test.expected {
+				t.Errorf("prefix %s: %s: upstream got %q, want %q", prefix, test.path, path, test.expected)
+			}
+		}
+	}
+}
+
+func TestProxyUpstreamHeader(t *testing.T) {
+	var header http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer upstream.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(tokenFile, []byte("Bearer from-file\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		settings config.Settings
+		auth     string
+	}{
+		{config.Settings{"upstream-auth": {"Bearer injected"}}, "Bearer injected"},
+		{config.Settings{"upstream-auth-file": {tokenFile}}, "Bearer from-file"},
+	} {
+		test.settings["target"] = []string{upstream.URL}
+		test.settings["upstream-header"] = []string{"X-Gateway: mock"}
+		proxy, err := handlers["proxy"](test.settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer client")
+		req.Header.Set("X-Gateway", "client")
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got := header.Values("Authorization"); !reflect.DeepEqual(got, []string{test.auth}) {
+			t.Errorf("got Authorization %v, want %s", got, test.auth)
+		}
+		if got := header.Values("X-Gateway"); !reflect.DeepEqual(got, []string{"mock"}) {
+			t.Errorf("got X-Gateway %v, want mock", got)
+		}
+	}
+}
+
+func TestProxyTLSVerify(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		settings config.Settings
+		code     int
+	}{
+		{"verify", config.Settings{}, http.StatusBadGateway},
+		{"insecure", config.Settings{"insecure": {"true"}}, http.StatusOK},
+		{"ca", config.Settings{"ca": {caFile}}, http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			test.settings["target"] = []string{upstream.URL}
+			proxy, err := handlers["proxy"](test.settings)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := httptest.NewRecorder()
+			proxy.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != test.code {
+				t.Fatalf("got %d, want %d", rec.Code, test.code)
+			}
+		})
+	}
+
+	_, err = handlers["proxy"](config.Settings{"target": {upstream.URL}, "insecure": {"true"}, "ca": {caFile}})
+	if err == nil {
+		t.Fatal("expected error if insecure and ca are set")
+	}
+}
+
+func TestProxyPreserveHost(t *testing.T) {
+	var host string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	for _, test := range []struct {
+		preserveHost string
+		host         string
+	}{
+		{"false", upstreamHost},
+		{"true", "www.example.com"},
+	} {
+		proxy, err := handlers["proxy"](config.Settings{"target": {upstream.URL}, "preserve-host": {test.preserveHost}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://www.example.com/", nil))
+		if host != test.host {
+			t.Errorf("preserve-host %s: upstream got host %q, want %q", test.preserveHost, host, test.host)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyProtoHeaderTimeout is the time a client has to send the PROXY
+// protocol header.
+const proxyProtoHeaderTimeout = 10 * time.Second
+
+// proxyProtoV2Signature is the start of every PROXY protocol v2 header.
+var proxyProtoV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtoListener expects the PROXY protocol header (v1 or v2) at the
+// start of each connection (e.g. from HAProxy or an AWS load balancer). The
+// client address of the header is returned as RemoteAddr of the connection.
+//
+// The headers are read in a goroutine per connection so that clients which do
+// not send a header do not block Accept. Connections are returned by Accept
+// once their header has been read. Connections with an invalid header are
+// closed.
+type proxyProtoListener struct {
+	net.Listener
+
+	conns chan net.Conn
+	errs  chan error
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newProxyProtoListener(l net.Listener) *proxyProtoListener {
+	p := &proxyProtoListener{
+		Listener: l,
+		conns:    make(chan net.Conn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go p.acceptLoop()
+	return p
+}
+
+func (l *proxyProtoListener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.readHeader(c)
+	}
+}
+
+func (l *proxyProtoListener) readHeader(c net.Conn) {
+	r := bufio.NewReader(c)
+	c.SetReadDeadline(time.Now().Add(proxyProtoHeaderTimeout))
+	remote, err := readProxyProtoHeader(r)
+	c.SetReadDeadline(time.Time{})
+	if err != nil {
+		log.Printf("invalid PROXY protocol header from %s: %s", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+	select {
+	case l.conns <- &proxyProtoConn{Conn: c, r: r, remote: remote}:
+	case <-l.done:
+		c.Close()
+	}
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *proxyProtoListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// proxyProtoConn is a connection whose PROXY protocol header has already been
+// read.
+type proxyProtoConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyProtoHeader reads a PROXY protocol v1 or v2 header and returns the
+// source address. The address is nil if the header does not contain one
+// (UNKNOWN, LOCAL or unsupported address families).
+func readProxyProtoHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(5)
+	if err != nil {
+		return nil, err
+	}
+	if string(start) == "PROXY" {
+		return readProxyProtoV1(r)
+	}
+	sig, err := r.Peek(len(proxyProtoV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(sig, proxyProtoV2Signature) {
+		return readProxyProtoV2(r)
+	}
+	return nil, fmt.Errorf("missing header")
+}
+
+// readProxyProtoV1 reads a header like PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n
+func readProxyProtoV1(r *bufio.Reader) (net.Addr, error) {
+	// the maximum length of a v1 header is 107 bytes
+	line := []byte{}
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= 107 {
+			return nil, fmt.Errorf("v1 header too long")
+		}
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid v1 header %q", line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid source address in v1 header %q", line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyProtoV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported version %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+	// LOCAL command (e.g. health checks of the proxy)
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+
+	var ipLen int
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		ipLen = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLen = net.IPv6len
+	default:
+		return nil, nil
+	}
+	// source address, destination address, source port, destination port
+	if len(payload) < 2*ipLen+4 {
+		return nil, fmt.Errorf("v2 address block too short")
+	}
+	ip := net.IP(payload[:ipLen])
+	port := binary.BigEndian.Uint16(payload[2*ipLen:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
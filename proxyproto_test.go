@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxyProtoV2Header(family byte, src net.IP, port uint16) string {
+	payload := append(append([]byte{}, src...), src...)
+	payload = binary.BigEndian.AppendUint16(payload, port)
+	payload = binary.BigEndian.AppendUint16(payload, 443)
+	header := append([]byte{}, proxyProtoV2Signature...)
+	header = append(header, 0x21, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return string(append(header, payload...))
+}
+
+func TestReadProxyProtoHeader(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		input  string
+		remote string
+		err    bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET", "192.0.2.1:56324", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nGET", "[2001:db8::1]:56324", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\nGET", "", false},
+		{"v1 invalid", "PROXY TCP4 foo 192.0.2.2 56324 443\r\nGET", "", true},
+		{"v1 too long", "PROXY " + strings.Repeat("A", 200) + "\r\n", "", true},
+		{"v2 tcp4", proxyProtoV2Header(0x11, net.IPv4(192, 0, 2, 1).To4(), 56324) + "GET", "192.0.2.1:56324", false},
+		{"v2 tcp6", proxyProtoV2Header(0x21, net.ParseIP("2001:db8::1"), 56324) + "GET", "[2001:db8::1]:56324", false},
+		{"missing", "GET / HTTP/1.1\r\n\r\n", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(test.input))
+			addr, err := readProxyProtoHeader(r)
+			if test.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			remote := ""
+			if addr != nil {
+				remote = addr.String()
+			}
+			if remote != test.remote {
+				t.Fatalf("got %q, want %q", remote, test.remote)
+			}
+			rest, _ := io.ReadAll(r)
+			if string(rest) != "GET" {
+				t.Fatalf("got remaining data %q, want GET", rest)
+			}
+		})
+	}
+}
+
+func TestProxyProtoListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	})}
+	go srv.Serve(newProxyProtoListener(l))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = io.WriteString(conn, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 80\r\nGET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "192.0.2.1:56324" {
+		t.Fatalf("got remote address %q, want 192.0.2.1:56324", body)
+	}
+}
+
+func TestProxyProtoListenerSilentClient(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.RemoteAddr)
+		}),
+		// the connection log calls RemoteAddr on StateNew in the accept loop
+		ConnState: newConnTracker(0).connState,
+	}
+	go srv.Serve(newProxyProtoListener(l))
+	defer srv.Close()
+
+	// a client which connects but never sends the header
+	silent, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = io.WriteString(conn, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 80\r\nGET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("second client is blocked by the silent client: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "192.0.2.1:56324" {
+		t.Fatalf("got remote address %q, want 192.0.2.1:56324", body)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// rng is the source for all randomized behavior of handlers and middlewares
+// (not for security relevant values which use crypto/rand). It can be seeded
+// with -seed to get reproducible results.
+var rng = newLockedRand(uint64(time.Now().UnixNano()))
+
+// lockedRand is a *rand.Rand which is safe for concurrent use.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRand(seed uint64) *lockedRand {
+	return &lockedRand{
+		r: rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+// seed resets the source with seed.
+func (l *lockedRand) seed(seed uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.r = rand.New(rand.NewPCG(seed, seed))
+}
+
+func (l *lockedRand) Float64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Float64()
+}
+
+func (l *lockedRand) IntN(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.IntN(n)
+}
+
+func (l *lockedRand) NormFloat64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.NormFloat64()
+}
+
+func (l *lockedRand) ExpFloat64() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.ExpFloat64()
+}
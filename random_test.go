@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dvob/http-server/config"
+)
+
+func TestSeed(t *testing.T) {
+	allowDestructive = true
+	defer func() { allowDestructive = false }()
+	mw, err := corrupt(config.Settings{"rate": {"0.5"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func() ([]int, []byte) {
+		rng.seed(42)
+		decisions := []int{}
+		for i := 0; i < 10; i++ {
+			decisions = append(decisions, rng.IntN(100))
+		}
+		rec := httptest.NewRecorder()
+		mw(dataHandler)(rec, httptest.NewRequest(http.MethodGet, "/?size=100", nil))
+		return decisions, rec.Body.Bytes()
+	}
+
+	decisions1, body1 := run()
+	decisions2, body2 := run()
+	if !reflect.DeepEqual(decisions1, decisions2) {
+		t.Fatalf("got different decisions for the same seed: %v, %v", decisions1, decisions2)
+	}
+	if !reflect.DeepEqual(body1, body2) {
+		t.Fatal("got different corrupted bodies for the same seed")
+	}
+}
+
+func TestDelayDistribution(t *testing.T) {
+	rng.seed(1)
+	for _, test := range []struct {
+		settings config.Settings
+		mean     time.Duration
+	}{
+		{config.Settings{"mean": {"100ms"}, "jitter": {"50ms"}}, 100 * time.Millisecond},
+		{config.Settings{"duration": {"100ms"}, "jitter": {"50ms"}}, 100 * time.Millisecond},
+		{config.Settings{"distribution": {"normal"}, "mean": {"100ms"}, "stddev": {"20ms"}}, 100 * time.Millisecond},
+		{config.Settings{"distribution": {"exponential"}, "mean": {"100ms"}}, 100 * time.Millisecond},
+		// negative values are clamped to zero which moves the mean up
+		{config.Settings{"distribution": {"normal"}, "mean": {"0s"}, "stddev": {"100ms"}}, 39894 * time.Microsecond},
+	} {
+		sample, err := newDelaySampler(test.settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		const n = 100000
+		sum := time.Duration(0)
+		for i := 0; i < n; i++ {
+			d := sample()
+			if d < 0 {
+				t.Fatalf("%v: got negative delay %s", test.settings, d)
+			}
+			sum += d
+		}
+		mean := sum / n
+		if diff := (mean - test.mean).Abs(); diff > test.mean/50 {
+			t.Errorf("%v: got mean %s, want %s", test.settings, mean, test.mean)
+		}
+	}
+
+	_, err := newDelaySampler(config.Settings{"distribution": {"pareto"}})
+	if err == nil {
+		t.Fatal("expected error for invalid distribution")
+	}
+
+	_, err = newDelaySampler(config.Settings{"mean": {"1s"}, "duration": {"1s"}})
+	if err == nil {
+		t.Fatal("expected error for mean and duration")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dvob/http-server/config"
+	"golang.org/x/time/rate"
+)
+
+// rateLimit limits the requests per client IP with a token bucket. The
+// setting rate is the number of requests per second and burst the size of
+// the bucket. Requests over the limit are answered with 429 and a
+// Retry-After header.
+func rateLimit(config config.Settings) (middleware, error) {
+	rawRate, ok := config.Lookup("rate")
+	if !ok {
+		return nil, fmt.Errorf("missing configuration 'rate'")
+	}
+	limit, err := strconv.ParseFloat(rawRate, 64)
+	if err != nil || limit <= 0 {
+		return nil, fmt.Errorf("invalid rate '%s'", rawRate)
+	}
+	burst, err := config.Int("burst", max(1, int(math.Ceil(limit))))
+	if err != nil {
+		return nil, err
+	}
+	if burst < 1 {
+		return nil, fmt.Errorf("invalid burst '%d': must be at least 1", burst)
+	}
+	maxClients, err := config.Int("max-clients", 10000)
+	if err != nil {
+		return nil, err
+	}
+	if maxClients < 1 {
+		return nil, fmt.Errorf("invalid max-clients '%d': must be at least 1", maxClients)
+	}
+	limiters := newClientLimiters(rate.Limit(limit), burst, maxClients)
+
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			reservation := limiters.get(host, time.Now()).Reserve()
+			delay := reservation.Delay()
+			if delay == 0 {
+				next(w, r)
+				return
+			}
+			reservation.Cancel()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			httpError(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		}
+	}, nil
+}
+
+// clientLimiters keeps a rate limiter per client. The number of clients is
+// bounded by maxClients. If the limit is reached, clients whose bucket is
+// full again are removed since a new limiter would behave the same. If this
+// is not enough the least recently seen client is removed.
+type clientLimiters struct {
+	limit      rate.Limit
+	burst      int
+	maxClients int
+
+	mu      sync.Mutex
+	clients map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+func newClientLimiters(limit rate.Limit, burst, maxClients int) *clientLimiters {
+	return &clientLimiters{
+		limit:      limit,
+		burst:      burst,
+		maxClients: maxClients,
+		clients:    map[string]*clientLimiter{},
+	}
+}
+
+func (c *clientLimiters) get(client string, now time.Time) *rate.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if l, ok := c.clients[client]; ok {
+		l.lastSeen = now
+		return l.Limiter
+	}
+
+	if len(c.clients) >= c.maxClients {
+		c.evict(now)
+	}
+	l := &clientLimiter{
+		Limiter:  rate.NewLimiter(c.limit, c.burst),
+		lastSeen: now,
+	}
+	c.clients[client] = l
+	return l.Limiter
+}
+
+func (c *clientLimiters) evict(now time.Time) {
+	oldest := ""
+	for client, l := range c.clients {
+		if l.TokensAt(now) >= float64(c.burst) {
+			delete(c.clients, client)
+			continue
+		}
+		if oldest == "" || l.lastSeen.Before(c.clients[oldest].lastSeen) {
+			oldest = client
+		}
+	}
+	if len(c.clients) >= c.maxClients {
+		delete(c.clients, oldest)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dvob/http-server/config"
+)
+
+func TestRateLimit(t *testing.T) {
+	mw, err := rateLimit(config.Settings{"rate": {"1"}, "burst": {"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := mw(newStaticResponseHandler().ServeHTTP)
+
+	do := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rec := do("192.0.2.1:1234")
+		if rec.Code != expected {
+			t.Fatalf("request %d: got %d, want %d", i, rec.Code, expected)
+		}
+		if expected == http.StatusTooManyRequests {
+			retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+			if err != nil || retryAfter < 1 {
+				t.Fatalf("invalid Retry-After %q", rec.Header().Get("Retry-After"))
+			}
+		}
+	}
+
+	// other port same client
+	if rec := do("192.0.2.1:5678"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d for same client on other port, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	// other client
+	if rec := do("192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("got %d for other client, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimitInvalid(t *testing.T) {
+	for _, settings := range []config.Settings{
+		{},
+		{"rate": {"0"}},
+		{"rate": {"1"}, "burst": {"0"}},
+		{"rate": {"1"}, "burst": {"-1"}},
+		{"rate": {"1"}, "max-clients": {"0"}},
+	} {
+		_, err := rateLimit(settings)
+		if err == nil {
+			t.Errorf("expected error for %v", settings)
+		}
+	}
+}
+
+func TestClientLimitersEviction(t *testing.T) {
+	limiters := newClientLimiters(1, 1, 2)
+	now := time.Now()
+
+	limiters.get("a", now).AllowN(now, 1)
+	limiters.get("b", now.Add(time.Millisecond)).AllowN(now, 1)
+	limiters.get("c", now.Add(2*time.Millisecond))
+	if len(limiters.clients) != 2 {
+		t.Fatalf("got %d clients, want 2", len(limiters.clients))
+	}
+	if _, ok := limiters.clients["a"]; ok {
+		t.Fatal("least recently seen client was not evicted")
+	}
+
+	// idle clients with a full bucket are evicted first
+	later := now.Add(time.Minute)
+	limiters.get("d", later)
+	if len(limiters.clients) != 1 {
+		t.Fatalf("got %d clients, want 1", len(limiters.clients))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSelfSigned(t *testing.T) {
+	cfg := newDefaultTLSConfig()
+	cfg.selfSigned = true
+	tlsConfig, err := cfg.getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := tlsConfig.Certificates[0].Leaf
+	if len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != "localhost" {
+		t.Fatalf("got DNS names %v, want [localhost]", leaf.DNSNames)
+	}
+	if len(leaf.IPAddresses) != 1 || leaf.IPAddresses[0].String() != "127.0.0.1" {
+		t.Fatalf("got IP addresses %v, want [127.0.0.1]", leaf.IPAddresses)
+	}
+
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestSelfSignedExclusive(t *testing.T) {
+	cfg := newDefaultTLSConfig()
+	cfg.selfSigned = true
+	cfg.hosts = "example.com"
+	_, err := cfg.getConfig()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
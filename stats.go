@@ -0,0 +1,82 @@
+package main
+
+import (
+	"expvar"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/felixge/httpsnoop"
+)
+
+// serverStats holds the counters which are published with expvar on
+// /debug/vars.
+type serverStats struct {
+	requests    expvar.Int
+	activeConns expvar.Int
+	bytesIn     expvar.Int
+	bytesOut    expvar.Int
+	statusCodes expvar.Map
+}
+
+var (
+	stats        = &serverStats{}
+	publishStats sync.Once
+)
+
+// publish registers the counters under the name http_server.
+func (s *serverStats) publish() {
+	publishStats.Do(func() {
+		m := expvar.NewMap("http_server")
+		m.Set("requests", &s.requests)
+		m.Set("active_connections", &s.activeConns)
+		m.Set("bytes_in", &s.bytesIn)
+		m.Set("bytes_out", &s.bytesOut)
+		m.Set("status_codes", &s.statusCodes)
+	})
+}
+
+// connState tracks the number of active connections.
+func (s *serverStats) connState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.activeConns.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		s.activeConns.Add(-1)
+	}
+}
+
+// handler counts the requests and serves the counters on /debug/vars.
+func (s *serverStats) handler(next http.Handler) http.Handler {
+	s.publish()
+	vars := expvar.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/debug/vars" {
+			vars.ServeHTTP(w, r)
+			return
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		m := httpsnoop.CaptureMetrics(next, w, r)
+
+		s.requests.Add(1)
+		s.bytesIn.Add(body.n.Load())
+		s.bytesOut.Add(m.Written)
+		s.statusCodes.Add(strconv.Itoa(m.Code), 1)
+	})
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	srv := httptest.NewUnstartedServer(stats.handler(http.HandlerFunc(echoHandler)))
+	srv.Config.ConnState = stats.connState
+	srv.Start()
+	defer srv.Close()
+
+	for i := 0; i < 3; i++ {
+		resp, err := http.Post(srv.URL, "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(srv.URL + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	vars := struct {
+		HTTPServer struct {
+			Requests          int64            `json:"requests"`
+			ActiveConnections int64            `json:"active_connections"`
+			BytesIn           int64            `json:"bytes_in"`
+			BytesOut          int64            `json:"bytes_out"`
+			StatusCodes       map[string]int64 `json:"status_codes"`
+		} `json:"http_server"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := vars.HTTPServer
+	if s.Requests != 3 || s.BytesIn != 15 || s.BytesOut != 15 || s.StatusCodes["200"] != 3 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+	if s.ActiveConnections < 1 {
+		t.Fatalf("expected at least one active connection, got %d", s.ActiveConnections)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+
+	"github.com/dvob/http-server/config"
+)
+
+// templateHandler renders an HTML template with the templateData of the
+// request.
+type templateHandler struct {
+	template    *template.Template
+	contentType string
+}
+
+func newTemplateHandler(config config.Settings) (http.Handler, error) {
+	rawTemplate, hasBody := config.Lookup("body")
+	file, hasFile := config.Lookup("file")
+	switch {
+	case hasBody && hasFile:
+		return nil, fmt.Errorf("'body' and 'file' can not be used together")
+	case hasFile:
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template: %w", err)
+		}
+		rawTemplate = string(content)
+	case !hasBody:
+		return nil, fmt.Errorf("missing configuration 'body' or 'file'")
+	}
+
+	tmpl, err := template.New("template").Parse(rawTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
+	}
+	contentType := "text/html; charset=utf-8"
+	if ct, ok := config.Lookup("content-type"); ok {
+		contentType = ct
+	}
+	return withContentHeaders(config, &templateHandler{
+		template:    tmpl,
+		contentType: contentType,
+	}), nil
+}
+
+func (t *templateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// render to a buffer first to be able to respond with 500 on errors
+	buf := &bytes.Buffer{}
+	err := t.template.Execute(buf, newTemplateData(r))
+	if err != nil {
+		httpError(w, "failed to render template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", t.contentType)
+	w.Write(buf.Bytes())
+}
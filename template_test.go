@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dvob/http-server/config"
+)
+
+func TestTemplateHandler(t *testing.T) {
+	cfg, err := config.Parse([]byte(`"/users/{id}": template{body: "<p>{{ .Method }} {{ .PathParams.id }} {{ .Query.Get \"q\" }} {{ .Header.Get \"X-Name\" }}</p>"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err := getHandler(cfg, slashRedirectMux)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/users/42?q=search", nil)
+	req.Header.Set("X-Name", "<script>")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body.String())
+	}
+	expected := "<p>POST 42 search &lt;script&gt;</p>"
+	if rec.Body.String() != expected {
+		t.Fatalf("got %q, want %q", rec.Body.String(), expected)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Fatalf("got Content-Type %q", ct)
+	}
+}
+
+func TestTemplateHandlerErrors(t *testing.T) {
+	_, err := newTemplateHandler(config.Settings{"body": {"{{ .Method "}})
+	if err == nil {
+		t.Fatal("expected error for invalid template")
+	}
+	_, err = newTemplateHandler(config.Settings{})
+	if err == nil {
+		t.Fatal("expected error for missing template")
+	}
+
+	h, err := newTemplateHandler(config.Settings{"body": {"{{ .DoesNotExist }}"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
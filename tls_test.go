@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for localhost with the
+// common name cn to dir and returns the paths of the certificate and key.
+func writeTestCert(t *testing.T, dir, cn string) (string, string) {
+	t.Helper()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeTestCertFiles(t, certFile, keyFile, cn, "localhost")
+	return certFile, keyFile
+}
+
+// writeTestCertFiles writes a self-signed certificate for dnsName and
+// 127.0.0.1 with the common name cn to certFile and keyFile.
+func writeTestCertFiles(t *testing.T, certFile, keyFile, cn, dnsName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{dnsName},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func serverCommonName(t *testing.T, addr string) string {
+	t.Helper()
+	return serverCommonNameSNI(t, addr, "")
+}
+
+func serverCommonNameSNI(t *testing.T, addr, serverName string) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, ServerName: serverName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestLogCertificate(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "server")
+
+	logOutput := &bytes.Buffer{}
+	log.SetOutput(logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	cfg := newDefaultTLSConfig()
+	cfg.certs = stringList{certFile}
+	cfg.keys = stringList{keyFile}
+	_, err := cfg.getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "expiry=" + leaf.NotAfter.Format(time.RFC3339)
+	if !strings.Contains(logOutput.String(), expected) {
+		t.Fatalf("log %q does not contain %q", logOutput.String(), expected)
+	}
+	if !strings.Contains(logOutput.String(), `subject="CN=server" sans=localhost,127.0.0.1`) {
+		t.Fatalf("log %q does not contain subject and sans", logOutput.String())
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "server")
+	clientCertFile, clientKeyFile := writeTestCert(t, t.TempDir(), "client")
+	otherCertFile, otherKeyFile := writeTestCert(t, t.TempDir(), "other")
+
+	cfg := newDefaultTLSConfig()
+	cfg.certs = stringList{certFile}
+	cfg.keys = stringList{keyFile}
+	cfg.clientCA = clientCertFile
+	tlsConfig, err := cfg.getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("got client auth %s, want %s", tlsConfig.ClientAuth, tls.RequireAndVerifyClientCert)
+	}
+
+	srv := httptest.NewUnstartedServer(newStaticResponseHandler())
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(certFile, keyFile string) error {
+		clientConfig := &tls.Config{InsecureSkipVerify: true}
+		if certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientConfig.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if err := get(clientCertFile, clientKeyFile); err != nil {
+		t.Fatalf("request with valid client certificate failed: %s", err)
+	}
+	if err := get("", ""); err == nil {
+		t.Fatal("request without client certificate succeeded")
+	}
+	if err := get(otherCertFile, otherKeyFile); err == nil {
+		t.Fatal("request with untrusted client certificate succeeded")
+	}
+
+	for _, test := range []struct {
+		clientCA   string
+		clientAuth string
+	}{
+		{"", "verify"},
+		{"", "invalid"},
+		{certFile + ".missing", ""},
+	} {
+		cfg := newDefaultTLSConfig()
+		cfg.certs = stringList{certFile}
+		cfg.keys = stringList{keyFile}
+		cfg.clientCA = test.clientCA
+		cfg.clientAuth = test.clientAuth
+		_, err := cfg.getConfig()
+		if err == nil {
+			t.Errorf("expected error for client CA %q and mode %q", test.clientCA, test.clientAuth)
+		}
+	}
+}
+
+func TestSNICertificates(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCertFiles(t, filepath.Join(dir, "a.crt"), filepath.Join(dir, "a.key"), "a", "a.example.com")
+	writeTestCertFiles(t, filepath.Join(dir, "b.crt"), filepath.Join(dir, "b.key"), "b", "b.example.com")
+	otherDir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, otherDir, "local")
+
+	cfg := newDefaultTLSConfig()
+	cfg.certs = stringList{certFile}
+	cfg.keys = stringList{keyFile}
+	cfg.certDir = dir
+	tlsConfig, err := cfg.getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// httptest.Server sets its own certificate which would be used if the
+	// client does not send SNI. Therefore we use a plain TLS listener.
+	l, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	addr := l.Addr().String()
+
+	for serverName, want := range map[string]string{
+		"a.example.com": "a",
+		"b.example.com": "b",
+		"localhost":     "local",
+		"c.example.com": "local",
+		"":              "local",
+	} {
+		if cn := serverCommonNameSNI(t, addr, serverName); cn != want {
+			t.Errorf("%q: got %s, want %s", serverName, cn, want)
+		}
+	}
+}
+
+func TestCertKeyMismatch(t *testing.T) {
+	cfg := newDefaultTLSConfig()
+	cfg.certs = stringList{"a.crt", "b.crt"}
+	cfg.keys = stringList{"a.key"}
+	_, err := cfg.getConfig()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestTLSProtocol(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "server")
+
+	cfg := newDefaultTLSConfig()
+	cfg.certs = stringList{certFile}
+	cfg.keys = stringList{keyFile}
+	cfg.minVersion = "1.2"
+	cfg.maxVersion = "1.2"
+	cfg.ciphers = "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"
+	tlsConfig, err := cfg.getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.NotFoundHandler())
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	// ServerName is required as otherwise the certificate of httptest.Server is used
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+	if state.Version != tls.VersionTLS12 {
+		t.Errorf("got version %s, want TLS 1.2", tls.VersionName(state.Version))
+	}
+	if state.CipherSuite != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("got cipher suite %s", tls.CipherSuiteName(state.CipherSuite))
+	}
+
+	_, err = tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, ServerName: "localhost", MinVersion: tls.VersionTLS13})
+	if err == nil {
+		t.Error("expected TLS 1.3 handshake to fail")
+	}
+}
+
+func TestTLSProtocolInvalid(t *testing.T) {
+	certFile, keyFile := writeTestCert(t, t.TempDir(), "server")
+	for _, test := range []struct {
+		name       string
+		minVersion string
+		maxVersion string
+		ciphers    string
+		err        string
+	}{
+		{"min version", "1.4", "", "", "invalid TLS version '1.4'"},
+		{"max version", "", "tls1.2", "", "invalid TLS version 'tls1.2'"},
+		{"min greater max", "1.3", "1.2", "", "is greater than"},
+		{"cipher", "", "", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,FOO", "invalid cipher suite 'FOO', valid options: TLS_"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newDefaultTLSConfig()
+			cfg.certs = stringList{certFile}
+			cfg.keys = stringList{keyFile}
+			cfg.minVersion = test.minVersion
+			cfg.maxVersion = test.maxVersion
+			cfg.ciphers = test.ciphers
+			_, err := cfg.getConfig()
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestACMEDirectory(t *testing.T) {
+	cfg := newDefaultTLSConfig()
+	cfg.hosts = "example.com"
+	cfg.cacheDir = t.TempDir()
+	cfg.acmeDirectory = "https://acme.example.com/directory"
+	cfg.acmeEmail = "admin@example.com"
+	_, err := cfg.getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.acmeManager.Client == nil || cfg.acmeManager.Client.DirectoryURL != cfg.acmeDirectory {
+		t.Fatalf("directory URL not set: %+v", cfg.acmeManager.Client)
+	}
+	if cfg.acmeManager.Email != cfg.acmeEmail {
+		t.Fatalf("got email %q, want %q", cfg.acmeManager.Email, cfg.acmeEmail)
+	}
+
+	cfg = newDefaultTLSConfig()
+	cfg.acmeDirectory = "https://acme.example.com/directory"
+	_, err = cfg.getConfig()
+	if err == nil {
+		t.Fatal("expected error without -tls-hosts")
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	for _, test := range []struct {
+		addr     string
+		target   string
+		location string
+	}{
+		{":443", "http://example.com/foo?bar=1", "https://example.com/foo?bar=1"},
+		{":443", "http://example.com:80/foo", "https://example.com/foo"},
+		{":8443", "http://example.com/foo", "https://example.com:8443/foo"},
+	} {
+		cfg := newDefaultTLSConfig()
+		cfg.redirect = true
+		srv := cfg.getRedirectServer(test.addr)
+		if srv.Addr != ":80" {
+			t.Fatalf("got redirect address %s, want :80", srv.Addr)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.target, nil))
+		if rec.Code != http.StatusMovedPermanently {
+			t.Fatalf("got %d, want %d", rec.Code, http.StatusMovedPermanently)
+		}
+		if location := rec.Header().Get("Location"); location != test.location {
+			t.Errorf("got location %s, want %s", location, test.location)
+		}
+	}
+
+	cfg := newDefaultTLSConfig()
+	if cfg.getRedirectServer(":443") != nil {
+		t.Fatal("redirect server without -tls-redirect")
+	}
+}
+
+func TestACMEConfigInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		hosts         string
+		renewBefore   time.Duration
+		httpChallenge bool
+		redirect      bool
+		err           string
+	}{
+		{"negative renew before", "example.com", -time.Hour, false, false, "invalid renew before duration '-1h0m0s'"},
+		{"http challenge without hosts", "", 0, true, false, "-tls-http-challenge requires -tls-hosts"},
+		{"http challenge with redirect", "example.com", 0, true, true, "-tls-redirect can not be used with -tls-http-challenge"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := newDefaultTLSConfig()
+			cfg.hosts = test.hosts
+			cfg.cacheDir = t.TempDir()
+			cfg.renewBefore = test.renewBefore
+			cfg.httpChallenge = test.httpChallenge
+			cfg.redirect = test.redirect
+			_, err := cfg.getConfig()
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestHTTPChallengeServer(t *testing.T) {
+	cfg := newDefaultTLSConfig()
+	cfg.hosts = "example.com"
+	cfg.cacheDir = t.TempDir()
+	cfg.httpChallenge = true
+	cfg.httpChallengeAddr = ":8080"
+	_, err := cfg.getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := cfg.getRedirectServer(":443")
+	if srv == nil {
+		t.Fatal("no server for -tls-http-challenge")
+	}
+	if srv.Addr != ":8080" {
+		t.Fatalf("got address %s, want :8080", srv.Addr)
+	}
+
+	// challenge requests are answered by the ACME manager (unknown token)
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/.well-known/acme-challenge/token", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("challenge: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// all other requests are redirected
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/foo", nil))
+	if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "https://example.com/foo" {
+		t.Fatalf("got %d %q, want redirect to https://example.com/foo", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/dvob/http-server/config"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// maxValidateBodySize limits the size of the request body which gets
+// validated.
+const maxValidateBodySize = 1_000_000 // 1MB
+
+// validateHandler validates the request body against a JSON schema. It
+// returns 200 if the body is valid and 400 with the validation errors
+// otherwise.
+type validateHandler struct {
+	schema *jsonschema.Schema
+}
+
+func newValidateHandler(config config.Settings) (http.Handler, error) {
+	file, ok := config.Lookup("schema")
+	if !ok {
+		return nil, fmt.Errorf("missing configuration 'schema'")
+	}
+	schema, err := jsonschema.NewCompiler().Compile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schema: %w", err)
+	}
+	return &validateHandler{
+		schema: schema,
+	}, nil
+}
+
+func (v *validateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, maxValidateBodySize)
+	doc, err := jsonschema.UnmarshalJSON(body)
+	if err != nil {
+		maxBytesErr := &http.MaxBytesError{}
+		if errors.As(err, &maxBytesErr) {
+			httpError(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		httpError(w, "invalid json: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = v.schema.Validate(doc)
+	if err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"valid":true}`)
+		return
+	}
+
+	validationErr := &jsonschema.ValidationError{}
+	if !errors.As(err, &validationErr) {
+		httpError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	err = json.NewEncoder(w).Encode(validationErr.BasicOutput())
+	if err != nil {
+		log.Println("failed to encode json:", err)
+	}
+}
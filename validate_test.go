@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dvob/http-server/config"
+)
+
+func TestValidate(t *testing.T) {
+	h, err := handlers["validate"](config.Settings{"schema": {"testdata/schema.json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		body string
+		code int
+	}{
+		{`{"name": "foo", "age": 42}`, http.StatusOK},
+		{`{"name": "foo"}`, http.StatusOK},
+		{`{"age": 42}`, http.StatusBadRequest},
+		{`{"name": "foo", "age": -1}`, http.StatusBadRequest},
+		{`{"name": `, http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body)))
+		if rec.Code != test.code {
+			t.Errorf("%s: got %d, want %d: %s", test.body, rec.Code, test.code, rec.Body.String())
+		}
+	}
+}